@@ -8,6 +8,7 @@ import (
 	"os"
 
 	"github.com/petertjmills/escpos-server/escpos"
+	"github.com/petertjmills/escpos-server/receipt"
 )
 
 func printTextStyles(p *escpos.Escpos) error {
@@ -117,18 +118,8 @@ func printImage(p *escpos.Escpos, imagePath string) error {
 }
 
 func printTable(p *escpos.Escpos) error {
-	p.Justify(escpos.JustifyCenter)
-	p.Size(2, 2).Write("Table Example\n")
-	p.Size(1, 1)
-	p.LineFeed()
-
-	p.Justify(escpos.JustifyLeft)
-
-	// Header
-	p.Bold(true)
-	p.Write("Item              Qty   Price\n")
-	p.Write("------------------------------\n")
-	p.Bold(false)
+	r := receipt.New(p, receipt.USD)
+	r.Header("Table Example")
 
 	// Items
 	items := []struct {
@@ -141,20 +132,12 @@ func printTable(p *escpos.Escpos) error {
 		{"Cookie", 3, 2.50},
 		{"Juice", 1, 4.00},
 	}
-
-	total := 0.0
 	for _, item := range items {
-		line := fmt.Sprintf("%-16s %3d   $%5.2f\n", item.name, item.qty, item.price*float64(item.qty))
-		p.Write(line)
-		total += item.price * float64(item.qty)
+		r.Item(item.name, item.qty, receipt.USD.Amount(item.price))
 	}
 
-	p.Write("------------------------------\n")
-	p.Bold(true)
-	p.Write(fmt.Sprintf("%-20s  $%5.2f\n", "Total:", total))
-	p.Bold(false)
-
-	return nil
+	r.Rule().Total("Total:", r.ItemsTotal())
+	return r.Err()
 }
 
 func printInternational(p *escpos.Escpos) error {
@@ -2,18 +2,25 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	mrand "math/rand/v2"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
 	dailyFns "github.com/petertjmills/escpos-server/daily"
+	"github.com/petertjmills/escpos-server/document"
 	"github.com/petertjmills/escpos-server/escpos"
 )
 
@@ -21,6 +28,10 @@ import (
 type HTTPWriter struct {
 	serverURL string
 	buffer    bytes.Buffer
+
+	Token   string       // bearer token, for servers with authentication
+	Client  *http.Client // http.DefaultClient if nil
+	Retries int          // times a failed job is sent again
 }
 
 func NewHTTPWriter(serverURL string) *HTTPWriter {
@@ -29,29 +40,112 @@ func NewHTTPWriter(serverURL string) *HTTPWriter {
 	}
 }
 
+// Returns a client that trusts the CAs of caFile besides the system ones and
+// presents the client certificate of certFile and keyFile, if they are set.
+func NewTLSClient(caFile, certFile, keyFile string) (*http.Client, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}, nil
+}
+
 func (hw *HTTPWriter) Write(p []byte) (n int, err error) {
 	return hw.buffer.Write(p)
 }
 
+// Sends the buffered data as one job. Failed attempts are retried with
+// exponential backoff, all with the same Idempotency-Key, so a job the server
+// printed before the response got lost isn't printed twice.
 func (hw *HTTPWriter) Flush() error {
 	if hw.buffer.Len() == 0 {
 		return nil
 	}
 
-	resp, err := http.Post(hw.serverURL+"/print", "application/octet-stream", &hw.buffer)
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	idempotencyKey := hex.EncodeToString(key)
+
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		retry, wait, err := hw.send(idempotencyKey)
+		if err == nil {
+			// Clear the buffer after successful send
+			hw.buffer.Reset()
+			return nil
+		}
+		if !retry || attempt >= hw.Retries {
+			return err
+		}
+		if wait == 0 {
+			// Jitter, so clients don't retry in step
+			wait = time.Duration(mrand.Int64N(int64(backoff))) + backoff/2
+			backoff = min(backoff*2, maxBackoff)
+		}
+		log.Printf("Retrying in %v: %v", wait.Round(time.Millisecond), err)
+		time.Sleep(wait)
+	}
+}
+
+const maxBackoff = 30 * time.Second
+
+// Sends the buffer once. Returns whether a failure is worth retrying and how
+// long the server asked to wait.
+func (hw *HTTPWriter) send(idempotencyKey string) (bool, time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, hw.serverURL+"/print", bytes.NewReader(hw.buffer.Bytes()))
+	if err != nil {
+		return false, 0, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Idempotency-Key", idempotencyKey)
+	if hw.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+hw.Token)
+	}
+	client := hw.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send data to server: %w", err)
+		return true, 0, fmt.Errorf("failed to send data to server: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	// 202 is a job the server keeps to print after a restart
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("server returned error: %s - %s", resp.Status, string(body))
+		err := fmt.Errorf("server returned error: %s - %s", resp.Status, string(body))
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		// A daily quota isn't worth waiting for
+		if seconds > int(maxBackoff.Seconds()) {
+			return false, 0, err
+		}
+		return retry, time.Duration(seconds) * time.Second, err
 	}
-
-	// Clear the buffer after successful send
-	hw.buffer.Reset()
-	return nil
+	return false, 0, nil
 }
 
 // DebugWriter captures all data written to it
@@ -110,8 +204,17 @@ func (dw *DebugWriter) PrettyPrint() string {
 func main() {
 	var (
 		serverURL = flag.String("server", "http://localhost:8080", "Server URL")
+		token     = flag.String("token", os.Getenv("ESCPOS_TOKEN"), "API token of the server, defaults to $ESCPOS_TOKEN")
+		caFile    = flag.String("ca", "", "CA bundle to verify an HTTPS server with, e.g. its self-signed certificate")
+		certFile  = flag.String("cert", "", "Client certificate for servers that require one")
+		keyFile   = flag.String("key", "", "Private key of -cert")
+		retries   = flag.Int("retries", 3, "Times a failed job is sent to the server again")
+		timeout   = flag.Duration("timeout", 30*time.Second, "Timeout of a request to the server")
 		text      = flag.String("text", "", "Text to print")
 		markdown  = flag.String("markdown", "", "Print receipt from markdown")
+		headings  = flag.String("heading-font", "", "TrueType or OpenType font file for markdown headings")
+		docFile   = flag.String("document", "", "Print a receipt document from a JSON or YAML file")
+		tmplDir   = flag.String("templates", "", "Directory of *.tmpl files replacing the daily templates")
 		daily     = flag.Bool("daily", false, "Print daily receipt")
 		debug     = flag.Bool("debug", false, "Debug mode - print raw commands instead of sending to server")
 	)
@@ -126,7 +229,19 @@ func main() {
 		writer = debugWriter
 	} else {
 		// Use HTTP writer
-		writer = NewHTTPWriter(*serverURL)
+		hw := NewHTTPWriter(*serverURL)
+		hw.Token = *token
+		hw.Retries = *retries
+		hw.Client = &http.Client{Timeout: *timeout}
+		if *caFile != "" || *certFile != "" {
+			client, err := NewTLSClient(*caFile, *certFile, *keyFile)
+			if err != nil {
+				log.Fatalf("Failed to set up TLS: %v", err)
+			}
+			client.Timeout = *timeout
+			hw.Client = client
+		}
+		writer = hw
 	}
 
 	// Create ESC/POS printer instance
@@ -149,10 +264,48 @@ func main() {
 		if err != nil {
 			log.Fatalf("Failed to read markdown file: %v", err)
 		}
-		p.WriteMarkdown(data)
-		p.LineFeed()
-		if _, err := p.Cut(); err != nil {
-			log.Fatalf("Failed to cut: %v", err)
+		fm, _, err := escpos.ParseFrontMatter(data)
+		if err != nil {
+			log.Fatalf("Failed to read markdown file: %v", err)
+		}
+		opts := []escpos.MarkdownOption{escpos.WithImageDir(filepath.Dir(*markdown))}
+		if *headings != "" {
+			f, err := escpos.LoadTrueTypeFont(*headings)
+			if err != nil {
+				log.Fatalf("Failed to load heading font: %v", err)
+			}
+			opts = append(opts, escpos.WithHeadingFont(f))
+		}
+		if _, err := p.WriteMarkdown(data, opts...); err != nil {
+			log.Fatalf("Failed to render markdown: %v", err)
+		}
+		// The front matter decides about cutting if it mentions it
+		if fm == nil || fm.Cut == nil {
+			p.LineFeed()
+			if _, err := p.Cut(); err != nil {
+				log.Fatalf("Failed to cut: %v", err)
+			}
+		}
+		if err := p.Print(); err != nil {
+			log.Fatalf("Failed to print: %v", err)
+		}
+	} else if *docFile != "" {
+		data, err := os.ReadFile(*docFile)
+		if err != nil {
+			log.Fatalf("Failed to read document: %v", err)
+		}
+		var doc *document.Document
+		switch strings.ToLower(filepath.Ext(*docFile)) {
+		case ".yaml", ".yml":
+			doc, err = document.DecodeYAML(data)
+		default:
+			doc, err = document.DecodeJSON(data)
+		}
+		if err != nil {
+			log.Fatalf("Failed to read document: %v", err)
+		}
+		if err := doc.Render(p, document.WithImageDir(filepath.Dir(*docFile))); err != nil {
+			log.Fatalf("Failed to render document: %v", err)
 		}
 		if err := p.Print(); err != nil {
 			log.Fatalf("Failed to print: %v", err)
@@ -162,8 +315,13 @@ func main() {
 		if err := godotenv.Load(); err != nil {
 			fmt.Println("Error loading .env file:", err)
 		}
+		if *tmplDir != "" {
+			if err := dailyFns.LoadTemplates(*tmplDir); err != nil {
+				log.Fatalf("Failed to load templates: %v", err)
+			}
+		}
 		// print current day like monday 25th July 2025
-		p.WriteMarkdown(fmt.Appendf([]byte("### "), time.Now().Format("Monday, 2 January 2006")))
+		p.WriteMarkdown(fmt.Appendf([]byte("### "), "%s", time.Now().Format("Monday, 2 January 2006")))
 
 		dailyWotd, _ := dailyFns.GetWordOfTheDay()
 		p.WriteMarkdown([]byte(dailyWotd))
@@ -174,7 +332,7 @@ func main() {
 		pollen, _ := dailyFns.GetPollenCount()
 		p.WriteMarkdown([]byte(pollen))
 		hn, _ := dailyFns.GetHackerNewsFront()
-		p.WriteMarkdown([]byte(hn))
+		p.WriteMarkdown([]byte(hn), escpos.WithLinkQRCodes(true))
 
 		p.LineFeed()
 		if _, err := p.Cut(); err != nil {
@@ -184,7 +342,7 @@ func main() {
 			log.Fatalf("Failed to print: %v", err)
 		}
 	} else {
-		log.Fatal("Please specify either -demo, -text, or -markdown")
+		log.Fatal("Please specify either -demo, -text, -markdown or -document")
 	}
 
 	if *debug {
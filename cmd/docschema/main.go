@@ -0,0 +1,28 @@
+// Command docschema writes the JSON Schema of the receipt document format.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/petertjmills/escpos-server/document"
+)
+
+func main() {
+	out := flag.String("o", "", "Output file, standard output if not set")
+	flag.Parse()
+
+	schema, err := document.Schema()
+	if err != nil {
+		log.Fatalf("Failed to generate schema: %v", err)
+	}
+	schema = append(schema, '\n')
+	if *out == "" {
+		os.Stdout.Write(schema)
+		return
+	}
+	if err := os.WriteFile(*out, schema, 0644); err != nil {
+		log.Fatalf("Failed to write schema: %v", err)
+	}
+}
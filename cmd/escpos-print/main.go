@@ -136,10 +136,19 @@ func main() {
 	p := escpos.New(writer)
 	p.SetConfig(escpos.ConfigEpsonTMT20II)
 
-	p.WriteMarkdown(input)
-	p.LineFeed()
-	if _, err := p.Cut(); err != nil {
-		log.Fatalf("Failed to cut: %v", err)
+	fm, _, err := escpos.ParseFrontMatter(input)
+	if err != nil {
+		log.Fatalf("Failed to read markdown: %v", err)
+	}
+	if _, err := p.WriteMarkdown(input); err != nil {
+		log.Fatalf("Failed to render markdown: %v", err)
+	}
+	// The front matter decides about cutting if it mentions it
+	if fm == nil || fm.Cut == nil {
+		p.LineFeed()
+		if _, err := p.Cut(); err != nil {
+			log.Fatalf("Failed to cut: %v", err)
+		}
 	}
 	if err := p.Print(); err != nil {
 		log.Fatalf("Failed to print: %v", err)
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/petertjmills/escpos-server/escpos"
+	"gopkg.in/yaml.v3"
+)
+
+// Scopes a token can have. Admin allows everything.
+const (
+	scopePrint   = "print"
+	scopePreview = "preview"
+	scopeDrawer  = "drawer"
+	scopeAdmin   = "admin"
+)
+
+var scopes = []string{scopePrint, scopePreview, scopeDrawer, scopeAdmin}
+
+// Token is an API token of the tokens file. Only the SHA-256 hash of the
+// token is stored; the token itself is shown once when it is created.
+type Token struct {
+	Name       string   `yaml:"name"`
+	Hash       string   `yaml:"hash"`
+	Scopes     []string `yaml:"scopes"`
+	Rate       int      `yaml:"rate,omitempty"`        // requests per minute, 0 for no limit
+	DailyPaper int64    `yaml:"daily_paper,omitempty"` // millimetres of paper printed per day, 0 for no limit
+	Validation string   `yaml:"validation,omitempty"`  // of raw jobs, the one of the limits if not set
+}
+
+// Reports whether the token has the scope.
+func (t *Token) Allows(scope string) bool {
+	return scope == "" || slices.Contains(t.Scopes, scope) || slices.Contains(t.Scopes, scopeAdmin)
+}
+
+// TokenFile is the tokens file, managed with the token subcommand.
+type TokenFile struct {
+	Tokens []*Token `yaml:"tokens"`
+}
+
+func loadTokens(path string) (*TokenFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f TokenFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if err := f.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &f, nil
+}
+
+func (f *TokenFile) validate() error {
+	names := map[string]bool{}
+	for _, t := range f.Tokens {
+		if t.Name == "" || names[t.Name] {
+			return fmt.Errorf("token names must be set and unique, got %q", t.Name)
+		}
+		names[t.Name] = true
+		if !strings.HasPrefix(t.Hash, "sha256:") {
+			return fmt.Errorf("token %s: the hash must start with sha256:", t.Name)
+		}
+		for _, s := range t.Scopes {
+			if !slices.Contains(scopes, s) {
+				return fmt.Errorf("token %s: unknown scope %q, use %s", t.Name, s, strings.Join(scopes, ", "))
+			}
+		}
+		if t.Rate < 0 || t.DailyPaper < 0 {
+			return fmt.Errorf("token %s: limits can't be negative", t.Name)
+		}
+		if err := checkValidation(t.Validation); err != nil {
+			return fmt.Errorf("token %s: %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// Writes the file, readable only by its owner.
+func (f *TokenFile) save(path string) error {
+	data, err := yaml.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// Returns a new random token.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Hashes a token for the tokens file. Tokens are random, so an unsalted hash
+// is as good as a password hash here and fast enough for every request.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Auth checks bearer tokens against the tokens file, which is read again
+// when it changes, and keeps the rate limit and quota of every token.
+type Auth struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	byHash  map[string]*Token
+	usage   map[string]*usage // by token name, kept across reloads
+}
+
+type usage struct {
+	allowance float64 // requests left in the bucket
+	last      time.Time
+	day       string
+	paper     float64 // millimetres printed on day
+}
+
+func newAuth(path string) (*Auth, error) {
+	a := &Auth{path: path, usage: map[string]*usage{}}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reads the tokens file if it changed. A file that fails to load keeps the
+// tokens that were loaded before.
+func (a *Auth) reload() error {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(a.modTime) && a.byHash != nil {
+		return nil
+	}
+	f, err := loadTokens(a.path)
+	if err != nil {
+		return err
+	}
+	byHash := map[string]*Token{}
+	for _, t := range f.Tokens {
+		byHash[t.Hash] = t
+	}
+	a.modTime, a.byHash = info.ModTime(), byHash
+	return nil
+}
+
+// Returns the token of the Authorization header.
+func (a *Auth) lookup(header string) (*Token, bool) {
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return nil, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.reload(); err != nil {
+		log.Printf("Failed to reload tokens: %v", err)
+	}
+	t, ok := a.byHash[hashToken(strings.TrimSpace(token))]
+	return t, ok
+}
+
+// Takes a request from the token bucket of t, returning how long to wait if
+// it is empty. The bucket holds a minute of requests.
+func (a *Auth) allow(t *Token, now time.Time) (bool, time.Duration) {
+	if t.Rate == 0 {
+		return true, 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u := a.usageOf(t.Name)
+	rate := float64(t.Rate)
+	if u.last.IsZero() {
+		u.allowance = rate
+	} else {
+		u.allowance = min(u.allowance+now.Sub(u.last).Minutes()*rate, rate)
+	}
+	u.last = now
+	if u.allowance < 1 {
+		return false, time.Duration((1 - u.allowance) / rate * float64(time.Minute))
+	}
+	u.allowance--
+	return true, 0
+}
+
+// Adds the paper of a job in millimetres to the daily quota of t, unless
+// that exceeds it. Returns how long until the quota is reset if it does.
+func (a *Auth) charge(t *Token, mm float64, now time.Time) (bool, time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u := a.usageOf(t.Name)
+	if day := now.Format(time.DateOnly); u.day != day {
+		u.day, u.paper = day, 0
+	}
+	if t.DailyPaper > 0 && u.paper+mm > float64(t.DailyPaper) {
+		y, m, d := now.Date()
+		return false, time.Date(y, m, d+1, 0, 0, 0, 0, now.Location()).Sub(now)
+	}
+	u.paper += mm
+	return true, 0
+}
+
+// Takes back the paper of a job that wasn't printed.
+func (a *Auth) refund(t *Token, mm float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.usageOf(t.Name).paper -= mm
+}
+
+// quota charges the paper of a job to the daily quota of its token. The
+// printer that takes the job charges it, from the data it renders to.
+type quota struct {
+	auth  *Auth
+	token *Token
+}
+
+// quotaError is the error of a job that exceeds the daily quota of its token.
+type quotaError struct {
+	limit int64         // millimetres a day
+	wait  time.Duration // until the quota is reset
+}
+
+func (e *quotaError) Error() string {
+	return fmt.Sprintf("daily quota of %d mm of paper exceeded", e.limit)
+}
+
+// Charges the paper of the ESC/POS data of a job.
+func (q *quota) charge(data []byte) error {
+	paper, _ := escpos.EstimatePaper(data)
+	if ok, wait := q.auth.charge(q.token, paper.Millimetres(), time.Now()); !ok {
+		return &quotaError{limit: q.token.DailyPaper, wait: wait}
+	}
+	return nil
+}
+
+// Gives back the paper of a job that failed after n bytes, what wasn't
+// printed.
+func (q *quota) refund(data []byte, n int) {
+	paper, _ := escpos.EstimatePaper(data)
+	printed, _ := escpos.EstimatePaper(data[:n])
+	q.auth.refund(q.token, paper.Millimetres()-printed.Millimetres())
+}
+
+func (a *Auth) usageOf(name string) *usage {
+	u, ok := a.usage[name]
+	if !ok {
+		u = &usage{}
+		a.usage[name] = u
+	}
+	return u
+}
+
+type tokenKey struct{}
+
+// Returns the token of an authenticated request, nil without authentication.
+func tokenFrom(ctx context.Context) *Token {
+	t, _ := ctx.Value(tokenKey{}).(*Token)
+	return t
+}
+
+// Wraps h to require a token with the scope, or any token if scope is empty.
+// Without a tokens file everything is allowed.
+func (s *Server) require(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := s.settings.Load().auth
+		if auth == nil {
+			h(w, r)
+			return
+		}
+		t, ok := auth.lookup(r.Header.Get("Authorization"))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="escpos-server"`)
+			http.Error(w, "Missing or unknown token", http.StatusUnauthorized)
+			return
+		}
+		if !t.Allows(scope) {
+			http.Error(w, fmt.Sprintf("Token %s lacks the %s scope", t.Name, scope), http.StatusForbidden)
+			return
+		}
+		if ok, wait := auth.allow(t, time.Now()); !ok {
+			tooManyRequests(w, wait, fmt.Sprintf("Rate limit of %d requests per minute exceeded", t.Rate))
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), tokenKey{}, t)))
+	}
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration, msg string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	http.Error(w, msg, http.StatusTooManyRequests)
+}
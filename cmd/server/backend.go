@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gousb"
+)
+
+// Backend is the connection to a printer. Writes come from one goroutine, the
+// queue worker of the printer, but Close can be called at any time.
+type Backend interface {
+	Write(data []byte) (int, error)
+	Close() error
+	String() string
+}
+
+// statusBackend is a backend that can ask the printer for its real-time
+// status.
+type statusBackend interface {
+	// Sends DLE EOT n for every n and returns the status bytes.
+	QueryStatus(ns ...byte) ([]byte, error)
+}
+
+// errNoStatus is returned by printers that don't answer status requests,
+// which says nothing about whether they are online.
+var errNoStatus = errors.New("printer didn't answer the status request")
+
+const statusTimeout = 2 * time.Second
+
+// Creates the backend of a printer config. Nothing is opened until the
+// first write, so a printer that is off doesn't stop the server.
+func newBackend(c *PrinterConfig) Backend {
+	switch c.Backend {
+	case "usb":
+		return &usbBackend{vendor: c.Vendor, product: c.Product, serial: c.Serial}
+	case "tcp":
+		return &tcpBackend{address: c.Address}
+	default:
+		return &fileBackend{path: c.Path}
+	}
+}
+
+// usbBackend writes to the bulk out endpoint of a USB printer. The device is
+// claimed on the first write and released after a failed write, so
+// unplugging and replugging the printer only fails the job in between.
+type usbBackend struct {
+	vendor, product uint16
+	serial          string
+
+	mu       sync.Mutex
+	ctx      *gousb.Context
+	dev      *gousb.Device
+	done     func()
+	endpoint *gousb.OutEndpoint
+	in       *gousb.InEndpoint // nil if the printer has no bulk in endpoint
+
+	opened     bool // the device was opened before
+	reconnects atomic.Uint64
+}
+
+func (b *usbBackend) Write(data []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.endpoint == nil {
+		if err := b.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := b.endpoint.Write(data)
+	if err != nil {
+		b.close()
+	}
+	return n, err
+}
+
+func (b *usbBackend) open() error {
+	b.ctx = gousb.NewContext()
+	dev, err := b.openDevice()
+	if err != nil {
+		b.close()
+		return err
+	}
+	b.dev = dev
+
+	intf, done, err := dev.DefaultInterface()
+	if err != nil {
+		b.close()
+		return fmt.Errorf("failed to claim interface: %w", err)
+	}
+	b.done = done
+
+	ep, err := intf.OutEndpoint(1)
+	if err != nil {
+		b.close()
+		return fmt.Errorf("failed to open endpoint: %w", err)
+	}
+	b.endpoint = ep
+	if b.opened {
+		b.reconnects.Add(1)
+	}
+	b.opened = true
+
+	for _, desc := range intf.Setting.Endpoints {
+		if desc.Direction == gousb.EndpointDirectionIn && desc.TransferType == gousb.TransferTypeBulk {
+			if b.in, err = intf.InEndpoint(desc.Number); err != nil {
+				b.close()
+				return fmt.Errorf("failed to open endpoint: %w", err)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+func (b *usbBackend) QueryStatus(ns ...byte) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.endpoint == nil {
+		if err := b.open(); err != nil {
+			return nil, err
+		}
+	}
+	if b.in == nil {
+		return nil, errNoStatus
+	}
+	buf := make([]byte, max(b.in.Desc.MaxPacketSize, 1))
+	status := make([]byte, 0, len(ns))
+	for _, n := range ns {
+		if _, err := b.endpoint.Write([]byte{0x10, 0x04, n}); err != nil {
+			b.close()
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), statusTimeout)
+		got, err := b.in.ReadContext(ctx, buf)
+		cancel()
+		if err != nil || got == 0 {
+			return nil, fmt.Errorf("%w: %v", errNoStatus, err)
+		}
+		// With automatic status back on other status bytes can come first
+		status = append(status, buf[got-1])
+	}
+	return status, nil
+}
+
+// Opens the device with the vendor and product ID, and the serial number if
+// one is configured.
+func (b *usbBackend) openDevice() (*gousb.Device, error) {
+	if b.serial == "" {
+		dev, err := b.ctx.OpenDeviceWithVIDPID(gousb.ID(b.vendor), gousb.ID(b.product))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open device: %w", err)
+		}
+		if dev == nil {
+			return nil, fmt.Errorf("no device %04x:%04x", b.vendor, b.product)
+		}
+		return dev, nil
+	}
+
+	devs, err := b.ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		return desc.Vendor == gousb.ID(b.vendor) && desc.Product == gousb.ID(b.product)
+	})
+	var found *gousb.Device
+	for _, dev := range devs {
+		if serial, _ := dev.SerialNumber(); found == nil && serial == b.serial {
+			found = dev
+			continue
+		}
+		dev.Close()
+	}
+	if found == nil {
+		if err != nil {
+			return nil, fmt.Errorf("failed to open device: %w", err)
+		}
+		return nil, fmt.Errorf("no device %04x:%04x with serial %s", b.vendor, b.product, b.serial)
+	}
+	return found, nil
+}
+
+func (b *usbBackend) close() {
+	if b.done != nil {
+		b.done()
+	}
+	if b.dev != nil {
+		b.dev.Close()
+	}
+	if b.ctx != nil {
+		b.ctx.Close()
+	}
+	b.ctx, b.dev, b.done, b.endpoint, b.in = nil, nil, nil, nil, nil
+}
+
+func (b *usbBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.close()
+	return nil
+}
+
+// Returns how often the device was opened again after it was released.
+func (b *usbBackend) Reconnects() uint64 {
+	return b.reconnects.Load()
+}
+
+func (b *usbBackend) String() string {
+	if b.serial != "" {
+		return fmt.Sprintf("usb %04x:%04x %s", b.vendor, b.product, b.serial)
+	}
+	return fmt.Sprintf("usb %04x:%04x", b.vendor, b.product)
+}
+
+// tcpBackend sends jobs to a network printer, usually on the raw port 9100,
+// with a connection per job.
+type tcpBackend struct {
+	address string
+}
+
+const tcpTimeout = 10 * time.Second
+
+func (b *tcpBackend) Write(data []byte) (int, error) {
+	conn, err := net.DialTimeout("tcp", b.hostPort(), tcpTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(tcpTimeout))
+	return conn.Write(data)
+}
+
+func (b *tcpBackend) QueryStatus(ns ...byte) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", b.hostPort(), statusTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(statusTimeout))
+	status := make([]byte, len(ns))
+	for i, n := range ns {
+		if _, err := conn.Write([]byte{0x10, 0x04, n}); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, status[i:i+1]); err != nil {
+			return nil, fmt.Errorf("%w: %v", errNoStatus, err)
+		}
+	}
+	return status, nil
+}
+
+func (b *tcpBackend) hostPort() string {
+	if _, _, err := net.SplitHostPort(b.address); err != nil {
+		return net.JoinHostPort(b.address, "9100")
+	}
+	return b.address
+}
+
+func (b *tcpBackend) Close() error {
+	return nil
+}
+
+func (b *tcpBackend) String() string {
+	return "tcp " + b.address
+}
+
+// fileBackend appends jobs to a file, like a printer device of the usblp
+// driver or a capture file for testing.
+type fileBackend struct {
+	path string
+}
+
+func (b *fileBackend) Write(data []byte) (int, error) {
+	f, err := os.OpenFile(b.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	n, err := f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+func (b *fileBackend) Close() error {
+	return nil
+}
+
+func (b *fileBackend) String() string {
+	return "file " + b.path
+}
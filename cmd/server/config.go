@@ -0,0 +1,377 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/petertjmills/escpos-server/escpos"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the config file of the server. Environment variables override
+// it, see applyEnv, and SIGHUP reloads it.
+//
+//	listen: ":8080"
+//	default: counter
+//	printers:
+//	  kitchen:
+//	    backend: tcp
+//	    address: 192.168.1.50:9100
+//	    profile: narrow
+//	  counter:
+//	    backend: usb
+//	    vendor: 0x04b8
+//	    product: 0x0e15
+//	profiles:
+//	  narrow:
+//	    base: epson-tm-t88ii
+//	    dots_per_line: 384
+//	groups:
+//	  any:
+//	    mode: failover
+//	    printers: [counter, kitchen]
+//	tokens: /etc/escpos-server/tokens.yaml
+//	tls:
+//	  cert: /etc/escpos-server/server.crt
+//	  key: /etc/escpos-server/server.key
+//	  self_signed: true
+//	  client_ca: /etc/escpos-server/tills.pem
+//	limits:
+//	  max_body: 1048576
+//	  read_timeout: 10s
+//	  validation: strip
+//	history:
+//	  path: /var/lib/escpos-server/jobs.db
+//	  size: 500
+//	  idempotency_window: 1h
+//	log:
+//	  file: /var/log/escpos-server.log
+//	  requests: true
+type Config struct {
+	Listen   string                    `yaml:"listen"`
+	Default  string                    `yaml:"default"` // printer or group of /print, optional with one printer
+	Printers map[string]*PrinterConfig `yaml:"printers"`
+	Groups   map[string]*GroupConfig   `yaml:"groups"`
+	Profiles map[string]*ProfileConfig `yaml:"profiles"` // besides escpos.Profiles
+	Tokens   string                    `yaml:"tokens"`   // tokens file, no authentication if not set
+	TLS      *TLSConfig                `yaml:"tls"`      // plain HTTP if not set
+	Limits   Limits                    `yaml:"limits"`
+	History  History                   `yaml:"history"`
+	Log      Log                       `yaml:"log"`
+}
+
+// Log is where the server logs to.
+type Log struct {
+	File     string `yaml:"file"`     // appended to and reopened on SIGHUP, stderr if not set
+	Requests bool   `yaml:"requests"` // log every request
+}
+
+// History is where the job history is kept for reprints. It is the spool of
+// the jobs held at a shutdown too.
+type History struct {
+	Path string `yaml:"path"` // bbolt database file
+	Size int    `yaml:"size"` // jobs kept, with their data
+
+	// How long the response to a request with an Idempotency-Key is
+	// returned to requests with the same key
+	IdempotencyWindow time.Duration `yaml:"idempotency_window"`
+}
+
+// Limits protects the server from clients.
+type Limits struct {
+	MaxBody     int64         `yaml:"max_body"`     // bytes of a request body
+	ReadTimeout time.Duration `yaml:"read_timeout"` // for reading a whole request
+	Validation  string        `yaml:"validation"`   // off, reject or strip dangerous commands of raw jobs
+
+	// How long a shutdown waits for the jobs being written
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// PrinterConfig is a printer and the backend it is reached through.
+type PrinterConfig struct {
+	Backend string `yaml:"backend"` // usb, tcp or file
+
+	// usb: the device, and its serial number to tell identical printers apart
+	Vendor  uint16 `yaml:"vendor"`
+	Product uint16 `yaml:"product"`
+	Serial  string `yaml:"serial"`
+
+	// tcp: host and port of a network printer, the port defaults to 9100
+	Address string `yaml:"address"`
+
+	// file: a device like /dev/usb/lp0, or a file jobs are appended to
+	Path string `yaml:"path"`
+
+	Profile string `yaml:"profile"` // profiles or escpos.Profiles name for rendering documents
+	Queue   int    `yaml:"queue"`   // jobs waiting before /print returns 503
+
+	// How often usb and tcp printers are asked for their paper and cover
+	// status, 5s if not set, negative to never ask
+	StatusInterval time.Duration `yaml:"status_interval"`
+
+	// Length of the paper rolls in metres, to warn near_end metres before a
+	// roll ends, 5 if not set
+	RollLength float64 `yaml:"roll_length"`
+	NearEnd    float64 `yaml:"near_end"`
+
+	profile escpos.PrinterConfig // of Profile
+}
+
+// ProfileConfig is a printer profile for rendering documents, one of
+// escpos.Profiles with changes.
+type ProfileConfig struct {
+	Base              string `yaml:"base"`          // escpos.Profiles name, a plain 80mm printer if not set
+	DotsPerLine       uint16 `yaml:"dots_per_line"` // printable width in dots
+	DisableUnderline  bool   `yaml:"disable_underline"`
+	DisableBold       bool   `yaml:"disable_bold"`
+	DisableReverse    bool   `yaml:"disable_reverse"`
+	DisableRotate     bool   `yaml:"disable_rotate"`
+	DisableUpsideDown bool   `yaml:"disable_upside_down"`
+	DisableJustify    bool   `yaml:"disable_justify"`
+}
+
+// Returns the profile with the changes applied to its base.
+func (p *ProfileConfig) resolve() (escpos.PrinterConfig, error) {
+	var c escpos.PrinterConfig
+	if p.Base != "" {
+		var ok bool
+		if c, ok = escpos.Profiles[p.Base]; !ok {
+			return c, fmt.Errorf("unknown base profile %q", p.Base)
+		}
+	}
+	if p.DotsPerLine > 0 {
+		c.DotsPerLine = p.DotsPerLine
+	}
+	c.DisableUnderline = c.DisableUnderline || p.DisableUnderline
+	c.DisableBold = c.DisableBold || p.DisableBold
+	c.DisableReverse = c.DisableReverse || p.DisableReverse
+	c.DisableRotate = c.DisableRotate || p.DisableRotate
+	c.DisableUpsideDown = c.DisableUpsideDown || p.DisableUpsideDown
+	c.DisableJustify = c.DisableJustify || p.DisableJustify
+	return c, nil
+}
+
+// GroupConfig is a set of printers that share jobs.
+type GroupConfig struct {
+	Mode     string   `yaml:"mode"` // failover or round-robin
+	Printers []string `yaml:"printers"`
+}
+
+const (
+	defaultProfile     = "epson-tm-t20ii"
+	defaultQueue       = 16
+	defaultStatus      = 5 * time.Second
+	defaultNearEnd     = 5
+	defaultMaxBody     = 8 << 20
+	defaultReadTimeout = 30 * time.Second
+	defaultShutdown    = 30 * time.Second
+	defaultHistoryPath = "jobs.db"
+	defaultHistorySize = 1000
+	defaultKeyWindow   = 24 * time.Hour
+)
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Reads a config file, applies the environment variables and validates it.
+// Unknown keys are an error, so typos don't go unnoticed.
+func loadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var c Config
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if err := applyEnv(&c); err != nil {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// Returns the config of a single USB printer, used without a config file,
+// with the environment variables applied.
+func usbConfig(listen string, vendor, product uint16) (*Config, error) {
+	c := &Config{
+		Listen: listen,
+		Printers: map[string]*PrinterConfig{
+			"default": {Backend: "usb", Vendor: vendor, Product: product},
+		},
+	}
+	if err := applyEnv(c); err != nil {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Checks the config and fills in defaults.
+func (c *Config) validate() error {
+	if len(c.Printers) == 0 {
+		return fmt.Errorf("no printers configured")
+	}
+	profiles := maps.Clone(escpos.Profiles)
+	for name, p := range c.Profiles {
+		if !validName.MatchString(name) {
+			return fmt.Errorf("profile %q: names can only contain letters, digits, - and _", name)
+		}
+		if p == nil {
+			return fmt.Errorf("profile %s: no settings", name)
+		}
+		profile, err := p.resolve()
+		if err != nil {
+			return fmt.Errorf("profile %s: %w", name, err)
+		}
+		profiles[name] = profile
+	}
+	for name, p := range c.Printers {
+		if !validName.MatchString(name) {
+			return fmt.Errorf("printer %q: names can only contain letters, digits, - and _", name)
+		}
+		if p == nil {
+			return fmt.Errorf("printer %s: no settings", name)
+		}
+		if err := p.validate(profiles); err != nil {
+			return fmt.Errorf("printer %s: %w", name, err)
+		}
+	}
+	for name, g := range c.Groups {
+		if !validName.MatchString(name) {
+			return fmt.Errorf("group %q: names can only contain letters, digits, - and _", name)
+		}
+		if _, ok := c.Printers[name]; ok {
+			return fmt.Errorf("group %s: a printer has the same name", name)
+		}
+		if g == nil || len(g.Printers) == 0 {
+			return fmt.Errorf("group %s: no printers", name)
+		}
+		switch g.Mode {
+		case "":
+			g.Mode = "failover"
+		case "failover", "round-robin":
+		default:
+			return fmt.Errorf("group %s: unknown mode %q, use failover or round-robin", name, g.Mode)
+		}
+		for _, member := range g.Printers {
+			if _, ok := c.Printers[member]; !ok {
+				return fmt.Errorf("group %s: unknown printer %q", name, member)
+			}
+		}
+	}
+
+	if c.TLS != nil {
+		if err := c.TLS.validate(); err != nil {
+			return err
+		}
+	}
+	if err := c.Limits.validate(); err != nil {
+		return fmt.Errorf("limits: %w", err)
+	}
+	if c.History.Size < 0 || c.History.IdempotencyWindow < 0 {
+		return fmt.Errorf("history: size and idempotency_window can't be negative")
+	}
+	c.History.Path = cmp.Or(c.History.Path, defaultHistoryPath)
+	c.History.Size = cmp.Or(c.History.Size, defaultHistorySize)
+	c.History.IdempotencyWindow = cmp.Or(c.History.IdempotencyWindow, defaultKeyWindow)
+
+	if c.Default == "" {
+		if len(c.Printers) > 1 || len(c.Groups) > 0 {
+			return fmt.Errorf("default is needed with more than one printer")
+		}
+		for name := range c.Printers {
+			c.Default = name
+		}
+	}
+	_, isPrinter := c.Printers[c.Default]
+	_, isGroup := c.Groups[c.Default]
+	if !isPrinter && !isGroup {
+		return fmt.Errorf("default: unknown printer or group %q", c.Default)
+	}
+	return nil
+}
+
+func (p *PrinterConfig) validate(profiles map[string]escpos.PrinterConfig) error {
+	switch p.Backend {
+	case "usb":
+		if p.Vendor == 0 || p.Product == 0 {
+			return fmt.Errorf("the usb backend needs vendor and product")
+		}
+	case "tcp":
+		if p.Address == "" {
+			return fmt.Errorf("the tcp backend needs an address")
+		}
+	case "file":
+		if p.Path == "" {
+			return fmt.Errorf("the file backend needs a path")
+		}
+	default:
+		return fmt.Errorf("unknown backend %q, use usb, tcp or file", p.Backend)
+	}
+	if p.Profile == "" {
+		p.Profile = defaultProfile
+	}
+	profile, ok := profiles[p.Profile]
+	if !ok {
+		return fmt.Errorf("unknown profile %q", p.Profile)
+	}
+	p.profile = profile
+	if p.Queue < 0 {
+		return fmt.Errorf("queue can't be negative")
+	}
+	if p.Queue == 0 {
+		p.Queue = defaultQueue
+	}
+	if p.StatusInterval == 0 {
+		p.StatusInterval = defaultStatus
+	}
+	if p.RollLength < 0 || p.NearEnd < 0 {
+		return fmt.Errorf("roll_length and near_end can't be negative")
+	}
+	if p.RollLength > 0 {
+		if p.NearEnd == 0 {
+			p.NearEnd = defaultNearEnd
+		}
+		if p.NearEnd >= p.RollLength {
+			return fmt.Errorf("near_end must be less than roll_length")
+		}
+	}
+	return nil
+}
+
+func (l *Limits) validate() error {
+	if l.MaxBody < 0 || l.ReadTimeout < 0 || l.ShutdownTimeout < 0 {
+		return fmt.Errorf("limits can't be negative")
+	}
+	if l.MaxBody == 0 {
+		l.MaxBody = defaultMaxBody
+	}
+	if l.ReadTimeout == 0 {
+		l.ReadTimeout = defaultReadTimeout
+	}
+	if l.ShutdownTimeout == 0 {
+		l.ShutdownTimeout = defaultShutdown
+	}
+	return checkValidation(l.Validation)
+}
+
+func checkValidation(mode string) error {
+	switch mode {
+	case "", validateOff, validateReject, validateStrip:
+		return nil
+	}
+	return fmt.Errorf("unknown validation %q, use off, reject or strip", mode)
+}
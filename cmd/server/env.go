@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const envPrefix = "ESCPOS"
+
+// Environment variables with the prefix that aren't settings: the config
+// file of the server and the token of the client.
+var otherEnv = map[string]bool{"ESCPOS_CONFIG": true, "ESCPOS_TOKEN": true}
+
+// Overrides settings of c with environment variables named ESCPOS_ and the
+// path of the setting in upper case, like ESCPOS_LISTEN,
+// ESCPOS_LIMITS_MAX_BODY or ESCPOS_PRINTERS_KITCHEN_ADDRESS. Values are
+// YAML, lists are separated by commas. Printers, groups and profiles have to
+// be in the config file for their settings to be overridden.
+func applyEnv(c *Config) error {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(k, envPrefix+"_") && !otherEnv[k] {
+			env[k] = v
+		}
+	}
+	if err := setEnv(reflect.ValueOf(c).Elem(), envPrefix, env); err != nil {
+		return err
+	}
+	// Whatever is left matches no setting
+	for _, k := range sortedKeys(env) {
+		log.Printf("Unknown setting %s in the environment, ignored", k)
+	}
+	return nil
+}
+
+// Sets v and what it holds from the variables of env named name, removing
+// the ones that are used.
+func setEnv(v reflect.Value, name string, env map[string]string) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := range v.NumField() {
+			tag, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+			if tag == "" || tag == "-" {
+				continue
+			}
+			if err := setEnv(v.Field(i), name+"_"+envName(tag), env); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Pointer:
+		if v.IsNil() {
+			// Sections like tls can be set by the environment alone
+			if !v.CanSet() || !hasEnvPrefix(env, name+"_") {
+				return nil
+			}
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setEnv(v.Elem(), name, env)
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			if err := setEnv(v.MapIndex(k), name+"_"+envName(k.String()), env); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	value, ok := env[name]
+	if !ok {
+		return nil
+	}
+	delete(env, name)
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		list := strings.Split(value, ",")
+		for i := range list {
+			list[i] = strings.TrimSpace(list[i])
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		// Numbers, booleans and durations are parsed like in the config file
+		if err := yaml.Unmarshal([]byte(value), v.Addr().Interface()); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Returns the part of an environment variable name for a YAML key or a
+// printer name.
+func envName(key string) string {
+	return strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+func hasEnvPrefix(env map[string]string, prefix string) bool {
+	for k := range env {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
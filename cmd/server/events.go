@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Types of events.
+const (
+	eventJobQueued    = "job.queued"
+	eventJobStarted   = "job.started"
+	eventJobFinished  = "job.finished"
+	eventJobFailed    = "job.failed"
+	eventJobCancelled = "job.cancelled"
+
+	eventStatus    = "printer.status" // the state of a printer when a client connects
+	eventOnline    = "printer.online"
+	eventOffline   = "printer.offline"
+	eventPaperLow  = "printer.paper_low"
+	eventPaperOut  = "printer.paper_out"
+	eventCoverOpen = "printer.cover_open"
+	eventReady     = "printer.ready" // the paper and cover problems are gone
+)
+
+// Event is a change of a job or printer.
+type Event struct {
+	ID      uint64         `json:"-"`
+	Type    string         `json:"type"`
+	Time    time.Time      `json:"time"`
+	Printer string         `json:"printer,omitempty"`
+	Job     uint64         `json:"job,omitempty"`
+	Target  string         `json:"target,omitempty"` // printer or group a job was sent to
+	Error   string         `json:"error,omitempty"`
+	Status  *PrinterStatus `json:"status,omitempty"`
+}
+
+// Events passes events on to the clients of GET /events.
+type Events struct {
+	mu     sync.Mutex
+	next   uint64
+	subs   map[chan Event]struct{}
+	closed bool
+}
+
+// A client that can't keep up with this many events is disconnected, and
+// gets the state of the printers again when it reconnects.
+const eventBuffer = 64
+
+func newEvents() *Events {
+	return &Events{subs: map[chan Event]struct{}{}}
+}
+
+// Sends an event to every client.
+func (e *Events) Publish(ev Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.next++
+	ev.ID = e.next
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	for ch := range e.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(e.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Returns a channel of the events from now on, and a function to stop
+// receiving them.
+func (e *Events) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBuffer)
+	e.mu.Lock()
+	if e.closed {
+		close(ch)
+	} else {
+		e.subs[ch] = struct{}{}
+	}
+	e.mu.Unlock()
+	return ch, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if _, ok := e.subs[ch]; ok {
+			delete(e.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Ends the streams of all clients, for shutting down.
+func (e *Events) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	for ch := range e.subs {
+		delete(e.subs, ch)
+		close(ch)
+	}
+}
+
+const eventPing = 30 * time.Second
+
+// Streams events as Server-Sent Events, starting with the state of every
+// printer, for the printer or group of the printer query parameter or all.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("printer")
+	if name != "" && s.target(name) == nil {
+		http.Error(w, fmt.Sprintf("Unknown printer %q", name), http.StatusNotFound)
+		return
+	}
+	// Subscribed before the state is written, so no change is missed
+	events, stop := s.events.Subscribe()
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	rc := http.NewResponseController(w)
+
+	var printers []*Printer
+	for _, p := range s.printers {
+		if s.concerns(name, p.Name) {
+			printers = append(printers, p)
+		}
+	}
+	sort.Slice(printers, func(i, j int) bool { return printers[i].Name < printers[j].Name })
+	for _, p := range printers {
+		status := p.Status()
+		writeEvent(w, Event{Type: eventStatus, Time: time.Now(), Printer: p.Name, Status: &status})
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ping := time.NewTicker(eventPing)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if !s.concerns(name, ev.Printer) && ev.Target != name {
+				continue
+			}
+			writeEvent(w, ev)
+		case <-ping.C:
+			// Keeps proxies from closing the connection
+			fmt.Fprint(w, ": ping\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// Returns whether events of a printer are wanted by a client of the printer
+// or group with the name, or of all printers if it is "".
+func (s *Server) concerns(name, printer string) bool {
+	if name == "" || name == printer {
+		return true
+	}
+	if g, ok := s.groups[name]; ok {
+		for _, p := range g.Printers {
+			if p.Name == printer {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func writeEvent(w http.ResponseWriter, ev Event) {
+	data, _ := json.Marshal(ev)
+	if ev.ID != 0 {
+		fmt.Fprintf(w, "id: %d\n", ev.ID)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+}
+
+// Browsers can't send headers with EventSource, so the token can be in the
+// access_token query parameter instead.
+func tokenParam(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if t := r.URL.Query().Get("access_token"); t != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+t)
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const maxKeyLength = 255
+
+// Runs a print request with an Idempotency-Key once. A request with a key
+// that was used within the idempotency window gets the response of the first
+// request, and waits for it if it is still running. Responses are only
+// remembered if the printer may have printed, so requests that failed
+// before can be retried with the same key. Keys are per client.
+func (s *Server) idempotent(w http.ResponseWriter, r *http.Request, key, hash string, run func() *printResult) {
+	if len(key) > maxKeyLength {
+		http.Error(w, fmt.Sprintf("Idempotency-Key is longer than %d characters", maxKeyLength), http.StatusBadRequest)
+		return
+	}
+	key = clientOf(r) + "\x00" + key
+
+	for {
+		s.mu.Lock()
+		running, ok := s.running[key]
+		if !ok {
+			s.running[key] = make(chan struct{})
+		}
+		s.mu.Unlock()
+		if !ok {
+			break
+		}
+		select {
+		case <-running:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	defer func() {
+		s.mu.Lock()
+		close(s.running[key])
+		delete(s.running, key)
+		s.mu.Unlock()
+	}()
+
+	window := s.settings.Load().keyWindow
+	res, err := s.jobs.GetKey(key, time.Now().Add(-window))
+	if err != nil {
+		log.Printf("Failed to read idempotency key: %v", err)
+	}
+	if res != nil {
+		if res.Hash != hash {
+			http.Error(w, "Idempotency-Key was used for a different request", http.StatusUnprocessableEntity)
+			return
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		res.write(w)
+		return
+	}
+
+	res = run()
+	if res.Printed {
+		res.Hash = hash
+		if err := s.jobs.PutKey(key, res, time.Now(), window); err != nil {
+			log.Printf("Failed to save idempotency key: %v", err)
+		}
+	}
+	res.write(w)
+}
+
+// Returns a hash of what a print request prints where.
+func requestHash(name string, job *Job, reprintOf uint64) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00", name, job.ContentType, strconv.FormatUint(reprintOf, 10))
+	h.Write(job.Data)
+	return hex.EncodeToString(h.Sum(nil))
+}
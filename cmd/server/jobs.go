@@ -0,0 +1,196 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Updates the record of a job when the printer is done with it.
+func (s *Server) finish(rec *JobRecord, job *Job, p *Printer, err error) {
+	s.mu.Lock()
+	delete(s.active, job.ID)
+	s.mu.Unlock()
+	if job.ID == 0 {
+		return
+	}
+
+	now := time.Now()
+	rec.Finished = &now
+	if started := job.started.Load(); started != 0 {
+		t := time.Unix(0, started)
+		rec.Started = &t
+	}
+	if p != nil {
+		rec.Printer = p.Name
+	}
+	ev := Event{Job: rec.ID, Target: rec.Target, Printer: rec.Printer}
+	switch {
+	case err == nil:
+		rec.Status, ev.Type = statusDone, eventJobFinished
+	case errors.Is(err, errCancelled) || job.state.Load() == jobCancelled:
+		rec.Status, ev.Type = statusCancelled, eventJobCancelled
+	case errors.Is(err, errShutdown):
+		rec.Status, rec.Printer, rec.Finished = statusHeld, "", nil
+	default:
+		rec.Status, rec.Error = statusFailed, err.Error()
+		ev.Type, ev.Error = eventJobFailed, rec.Error
+	}
+	if err := s.jobs.Update(rec); err != nil {
+		log.Printf("Failed to update job %d in history: %v", rec.ID, err)
+	}
+	if ev.Type != "" {
+		s.events.Publish(ev)
+	}
+	s.metrics.jobs.Add(1, labels("printer", cmp.Or(rec.Printer, rec.Target), "status", rec.Status, "content_type", rec.ContentType))
+}
+
+// Returns who sent a request: the token name, the name of the client
+// certificate or the address.
+func clientOf(r *http.Request) string {
+	if t := tokenFrom(r.Context()); t != nil {
+		return t.Name
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Reports whether the client of a request may see, cancel and reprint a job:
+// its own jobs, and every job with the admin scope or without a tokens file.
+func (s *Server) owns(r *http.Request, rec *JobRecord) bool {
+	if s.settings.Load().auth == nil {
+		return true
+	}
+	if t := tokenFrom(r.Context()); t != nil && t.Allows(scopeAdmin) {
+		return true
+	}
+	return rec.Client == clientOf(r)
+}
+
+// Lists the newest jobs of the client, filtered by the status, printer and client query
+// parameters, up to limit, 50 if not given.
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := 50
+	if l := q.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			http.Error(w, "The limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+	recs, err := s.jobs.List(limit, func(rec *JobRecord) bool {
+		if !s.owns(r, rec) {
+			return false
+		}
+		s.liveStatus(rec)
+		return (q.Get("status") == "" || rec.Status == q.Get("status")) &&
+			(q.Get("printer") == "" || rec.Printer == q.Get("printer") || rec.Target == q.Get("printer")) &&
+			(q.Get("client") == "" || rec.Client == q.Get("client"))
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read job history: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recs)
+}
+
+// Sets the status of a record of a job that didn't finish from the job.
+func (s *Server) liveStatus(rec *JobRecord) {
+	s.mu.Lock()
+	job, ok := s.active[rec.ID]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	rec.Status = job.status()
+	if started := job.started.Load(); started != 0 {
+		t := time.Unix(0, started)
+		rec.Started = &t
+	}
+}
+
+// Cancels a job that is waiting in a queue.
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		return
+	}
+	// Jobs of other clients are as good as unknown
+	rec, _, err := s.jobs.Get(id)
+	switch {
+	case errors.Is(err, errNoJob) || err == nil && !s.owns(r, rec):
+		http.Error(w, fmt.Sprintf("No job %d", id), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Failed to read job history: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.mu.Lock()
+	job, ok := s.active[id]
+	s.mu.Unlock()
+	if ok && job.Cancel() {
+		fmt.Fprintf(w, "Cancelled job %d\n", id)
+		return
+	}
+	s.liveStatus(rec)
+	http.Error(w, fmt.Sprintf("Job %d is %s and can't be cancelled", id, rec.Status), http.StatusConflict)
+}
+
+// Prints a job of the history again, on the printer query parameter or the
+// printer or group it was sent to. Raw jobs are checked like new ones, with
+// the token of the reprint.
+func (s *Server) handleReprint(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		return
+	}
+	rec, data, err := s.jobs.Get(id)
+	switch {
+	case errors.Is(err, errNoJob) || err == nil && !s.owns(r, rec):
+		http.Error(w, fmt.Sprintf("No job %d", id), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Failed to read job history: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	job := &Job{Data: data, ContentType: rec.ContentType}
+	token := tokenFrom(r.Context())
+	switch {
+	case documentTypes[rec.ContentType]:
+		if job.Doc, err = decodeDocument(rec.ContentType, data); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid document: %v", err), http.StatusBadRequest)
+			return
+		}
+	case token != nil && !token.Allows(scopeDrawer) && hasDrawer(data):
+		http.Error(w, fmt.Sprintf("Job %d opens the cash drawer and token %s lacks the %s scope", id, token.Name, scopeDrawer), http.StatusForbidden)
+		return
+	default:
+		if job.Data, err = s.validate(data, token); err != nil {
+			http.Error(w, fmt.Sprintf("Rejected ESC/POS data: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	target := rec.Target
+	if p := r.URL.Query().Get("printer"); p != "" {
+		target = p
+	}
+	s.print(w, r, target, job, id)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// Clients see, cancel and reprint their own jobs only, the admin scope every
+// job.
+func TestJobsOfOtherClients(t *testing.T) {
+	s, ts := startServer(t, nil,
+		&Token{Name: "alice", Scopes: []string{scopePrint}},
+		&Token{Name: "bob", Scopes: []string{scopePrint}},
+		&Token{Name: "manager", Scopes: []string{scopePrint, scopeAdmin}},
+	)
+	for _, token := range []string{"alice", "bob"} {
+		if code, body := request(t, ts, token, http.MethodPost, "/printers/p/print", rawType, []byte(token+"\n")); code != http.StatusOK {
+			t.Fatalf("%s: got %d %s", token, code, body)
+		}
+	}
+
+	for _, tt := range []struct {
+		token, query string
+		want         []uint64
+	}{
+		{"alice", "", []uint64{1}},
+		{"bob", "", []uint64{2}},
+		{"alice", "?client=bob", nil},
+		{"manager", "", []uint64{2, 1}},
+		{"manager", "?client=bob", []uint64{2}},
+	} {
+		code, body := request(t, ts, tt.token, http.MethodGet, "/jobs"+tt.query, "", nil)
+		var recs []JobRecord
+		if code != http.StatusOK || json.Unmarshal([]byte(body), &recs) != nil {
+			t.Fatalf("%s: got %d %s", tt.token, code, body)
+		}
+		var ids []uint64
+		for _, rec := range recs {
+			ids = append(ids, rec.ID)
+		}
+		if !slices.Equal(ids, tt.want) {
+			t.Errorf("%s lists %v with %q, want %v", tt.token, ids, tt.query, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		token, method, path string
+		want                int
+	}{
+		{"alice", http.MethodDelete, "/jobs/2", http.StatusNotFound},
+		{"alice", http.MethodPost, "/jobs/2/reprint", http.StatusNotFound},
+		{"alice", http.MethodDelete, "/jobs/3", http.StatusNotFound},
+		// Printed already
+		{"bob", http.MethodDelete, "/jobs/2", http.StatusConflict},
+		{"manager", http.MethodDelete, "/jobs/1", http.StatusConflict},
+		{"bob", http.MethodPost, "/jobs/2/reprint", http.StatusOK},
+		{"manager", http.MethodPost, "/jobs/1/reprint", http.StatusOK},
+	} {
+		if code, body := request(t, ts, tt.token, tt.method, tt.path, "", nil); code != tt.want {
+			t.Errorf("%s %s by %s: got %d %s, want %d", tt.method, tt.path, tt.token, code, body, tt.want)
+		}
+	}
+	out := printed(t, s)
+	if bytes.Count(out, []byte("alice\n")) != 2 || bytes.Count(out, []byte("bob\n")) != 2 {
+		t.Errorf("printed %q, want both jobs twice", out)
+	}
+}
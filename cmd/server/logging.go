@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// The file the log goes to, nil for stderr.
+var logFile *os.File
+
+// Sends the log to the file at path, appending to it, or to stderr if path
+// is empty. Calling it again opens the file again, after logrotate moved it.
+func setLogFile(path string) error {
+	var f *os.File
+	if path != "" {
+		var err error
+		if f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err != nil {
+			return err
+		}
+		log.SetOutput(f)
+	} else {
+		log.SetOutput(os.Stderr)
+	}
+	// Nothing is written to the old file after SetOutput returns
+	if logFile != nil {
+		logFile.Close()
+	}
+	logFile = f
+	return nil
+}
+
+// Wraps h to log every request if the log settings ask for it. Query
+// parameters aren't logged, they can hold an access token.
+func (s *Server) logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.settings.Load().config.Log.Requests {
+			h.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+		log.Printf("%s %s %s %d %d bytes %v", clientOf(r), r.Method, r.URL.Path, sw.status, sw.n, time.Since(start).Round(time.Millisecond))
+	})
+}
+
+// statusWriter keeps the status and size of a response for the log.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	n      int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n += n
+	return n, err
+}
+
+// Lets http.ResponseController flush event streams.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -1,193 +1,178 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"sync"
-
-	"github.com/google/gousb"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
-const systemdService = `[Unit]
-Description=ESC/POS USB Printer Server
-After=network.target
-
-[Service]
-Type=simple
-User=%s
-WorkingDirectory=%s
-ExecStart=%s --port 8080 --vendor 0x04b8 --product 0x0e15
-Restart=on-failure
-RestartSec=5
-
-[Install]
-WantedBy=multi-user.target
-`
-
-func installService() {
-	// Check if running as root
-	if os.Geteuid() != 0 {
-		log.Fatal("install-service must be run as root (use sudo)")
-	}
-
-	// Get the user who ran sudo
-	currentUser := os.Getenv("SUDO_USER")
-	if currentUser == "" {
-		log.Fatal("Could not determine the original user. Make sure to run with sudo.")
-	}
-
-	execPath, err := os.Executable()
-	if err != nil {
-		log.Fatalf("Failed to get executable path: %v", err)
-	}
-	execPath, _ = filepath.EvalSymlinks(execPath)
-	workingDir := filepath.Dir(execPath)
-
-	// Blacklist usblp module
-	blacklistContent := "# Blacklist usblp module to allow direct USB printer access\nblacklist usblp\n"
-	if err := os.WriteFile("/etc/modprobe.d/blacklist-usblp.conf", []byte(blacklistContent), 0644); err != nil {
-		log.Fatalf("Failed to write blacklist file: %v", err)
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "install-service" {
+		installService()
 	}
-	fmt.Println("Blacklisted usblp module")
-
-	// Remove usblp if currently loaded
-	exec.Command("rmmod", "usblp").Run() // Ignore errors
-
-	serviceContent := fmt.Sprintf(systemdService, currentUser, workingDir, execPath)
-	servicePath := "/etc/systemd/system/escpos-server.service"
-
-	// Write the service file
-	if err := os.WriteFile(servicePath, []byte(serviceContent), 0644); err != nil {
-		log.Fatalf("Failed to write service file: %v", err)
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		if err := tokenCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
-	fmt.Println("Systemd service file written to", servicePath)
-
-	// ... rest of the systemctl commands
-}
-
-type PrinterServer struct {
-	ctx      *gousb.Context
-	dev      *gousb.Device
-	intf     *gousb.Interface
-	done     func()
-	endpoint *gousb.OutEndpoint
-	mu       sync.Mutex
-
-	vendorID  uint16
-	productID uint16
-}
-
-func NewPrinterServer(vendorID, productID uint16) (*PrinterServer, error) {
-	ctx := gousb.NewContext()
+	var (
+		port       = flag.String("port", "8080", "HTTP server port")
+		vendorID   = flag.Uint("vendor", 0x04b8, "USB vendor ID")
+		productID  = flag.Uint("product", 0x0e15, "USB product ID")
+		configFile = flag.String("config", os.Getenv("ESCPOS_CONFIG"), "Config file, instead of the single USB printer of -vendor and -product, defaults to $ESCPOS_CONFIG")
+		tokensFile = flag.String("tokens", "", "Tokens file, overriding tokens of the config file")
+		tlsCert    = flag.String("tls-cert", "", "Certificate file, to serve HTTPS")
+		tlsKey     = flag.String("tls-key", "", "Private key file of -tls-cert")
+		selfSigned = flag.Bool("self-signed", false, "Generate a self-signed -tls-cert and -tls-key if they don't exist")
+		clientCA   = flag.String("client-ca", "", "Only accept clients with a certificate signed by a CA of this file")
+		maxBody    = flag.Int64("max-body", 0, "Maximum request body in bytes, overriding the config file")
+		validation = flag.String("validation", "", "Validation of raw jobs, off, reject or strip, overriding the config file")
+	)
+	flag.Parse()
 
-	dev, err := ctx.OpenDeviceWithVIDPID(gousb.ID(vendorID), gousb.ID(productID))
+	// Reads the config file, or the environment without one, and applies
+	// the flags. Used again on SIGHUP.
+	load := func() (*Config, error) {
+		var config *Config
+		var err error
+		if *configFile != "" {
+			if config, err = loadConfig(*configFile); err != nil {
+				return nil, err
+			}
+			if config.Listen == "" {
+				config.Listen = ":" + *port
+			}
+		} else if config, err = usbConfig(":"+*port, uint16(*vendorID), uint16(*productID)); err != nil {
+			return nil, err
+		}
+
+		if *tokensFile != "" {
+			config.Tokens = *tokensFile
+		}
+		if *maxBody > 0 {
+			config.Limits.MaxBody = *maxBody
+		}
+		if *validation != "" {
+			if err := checkValidation(*validation); err != nil {
+				return nil, fmt.Errorf("invalid flags: %w", err)
+			}
+			config.Limits.Validation = *validation
+		}
+		if *tlsCert != "" || *tlsKey != "" {
+			config.TLS = &TLSConfig{Cert: *tlsCert, Key: *tlsKey, SelfSigned: *selfSigned, ClientCA: *clientCA}
+			if err := config.TLS.validate(); err != nil {
+				return nil, fmt.Errorf("invalid flags: %w", err)
+			}
+		}
+		return config, nil
+	}
+
+	config, err := load()
 	if err != nil {
-		ctx.Close()
-		return nil, fmt.Errorf("failed to open device: %w", err)
+		log.Fatalf("Failed to load config: %v", err)
 	}
-
-	intf, done, err := dev.DefaultInterface()
-	if err != nil {
-		dev.Close()
-		ctx.Close()
-		return nil, fmt.Errorf("failed to claim interface: %w", err)
+	if err := setLogFile(config.Log.File); err != nil {
+		log.Fatalf("Failed to open log file: %v", err)
 	}
-
-	ep, err := intf.OutEndpoint(1)
+	s, err := NewServer(config)
 	if err != nil {
-		done()
-		dev.Close()
-		ctx.Close()
-		return nil, fmt.Errorf("failed to open endpoint: %w", err)
-	}
-
-	return &PrinterServer{
-		ctx:       ctx,
-		dev:       dev,
-		intf:      intf,
-		done:      done,
-		endpoint:  ep,
-		vendorID:  vendorID,
-		productID: productID,
-	}, nil
-}
-
-func (ps *PrinterServer) Close() {
-	if ps.done != nil {
-		ps.done()
-	}
-	if ps.dev != nil {
-		ps.dev.Close()
-	}
-	if ps.ctx != nil {
-		ps.ctx.Close()
-	}
+		log.Fatalf("Failed to start server: %v", err)
+	}
+	if s.settings.Load().auth == nil {
+		log.Printf("No tokens file, anyone who can reach the server can print")
+	}
+	for _, p := range s.printers {
+		log.Printf("Printer %s: %s", p.Name, p.backend)
+	}
+
+	server := &http.Server{
+		Addr:              config.Listen,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       config.Limits.ReadTimeout,
+		IdleTimeout:       2 * time.Minute,
+	}
+	if config.TLS != nil {
+		if server.TLSConfig, err = config.TLS.serverConfig(); err != nil {
+			log.Fatalf("Failed to set up TLS: %v", err)
+		}
+		if config.TLS.ClientCA != "" {
+			log.Printf("Only clients with a certificate of %s are accepted", config.TLS.ClientCA)
+		}
+	}
+
+	stop, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	failed := make(chan error, 1)
+	go func() {
+		if config.TLS == nil {
+			log.Printf("Starting server on %s", config.Listen)
+			failed <- server.ListenAndServe()
+		} else {
+			log.Printf("Starting HTTPS server on %s", config.Listen)
+			failed <- server.ListenAndServeTLS("", "")
+		}
+	}()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+running:
+	for {
+		select {
+		case err := <-failed:
+			s.Close()
+			log.Fatalf("Server failed: %v", err)
+		case <-hup:
+			reload(s, load)
+		case <-stop.Done():
+			break running
+		}
+	}
+	// A second signal kills the server right away
+	cancel()
+
+	timeout := s.settings.Load().limits.ShutdownTimeout
+	log.Printf("Shutting down, waiting up to %v for jobs being printed", timeout)
+	ctx, done := context.WithTimeout(context.Background(), timeout)
+	defer done()
+	if err := s.Shutdown(ctx, server); err != nil {
+		log.Printf("Shutdown: %v", err)
+		os.Exit(1)
+	}
+	log.Printf("Shut down")
 }
 
-func (ps *PrinterServer) handlePrint(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// Read the raw data from the request body
-	data, err := io.ReadAll(r.Body)
+// Loads the config again and applies what can change while the server runs.
+// A config that fails to load or validate keeps the running one. The log
+// file is opened again either way.
+func reload(s *Server, load func() (*Config, error)) {
+	config, err := load()
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		if err := setLogFile(s.settings.Load().config.Log.File); err != nil {
+			log.Printf("Failed to open log file again: %v", err)
+		}
+		log.Printf("Reload failed, keeping the current config: %v", err)
 		return
 	}
-	defer r.Body.Close()
-
-	// Lock to ensure thread-safe access to the USB device
-	ps.mu.Lock()
-	defer ps.mu.Unlock()
-
-	// Write the data to the printer
-	_, err = ps.endpoint.Write(data)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to write to printer: %v", err), http.StatusInternalServerError)
+	if err := setLogFile(config.Log.File); err != nil {
+		log.Printf("Reload failed, keeping the current config: failed to open log file: %v", err)
 		return
 	}
-
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "Successfully sent %d bytes to printer\n", len(data))
-}
-
-func main() {
-	if len(os.Args) > 1 && os.Args[1] == "install-service" {
-		installService()
-	}
-	var (
-		port      = flag.String("port", "8080", "HTTP server port")
-		vendorID  = flag.Uint("vendor", 0x04b8, "USB vendor ID")
-		productID = flag.Uint("product", 0x0e15, "USB product ID")
-	)
-	flag.Parse()
-
-	// Initialize the printer server
-	ps, err := NewPrinterServer(uint16(*vendorID), uint16(*productID))
+	restart, err := s.Reload(config)
 	if err != nil {
-		log.Fatalf("Failed to initialize printer: %v", err)
+		log.Printf("Reload failed, keeping the current config: %v", err)
+		return
 	}
-	defer ps.Close()
-
-	// Set up HTTP routes
-	http.HandleFunc("/print", ps.handlePrint)
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintln(w, "OK")
-	})
-
-	log.Printf("Starting server on port %s", *port)
-	log.Printf("Connected to printer (VID: 0x%04x, PID: 0x%04x)", *vendorID, *productID)
-
-	if err := http.ListenAndServe(":"+*port, nil); err != nil {
-		log.Fatalf("Server failed: %v", err)
+	log.Printf("Reloaded config")
+	if len(restart) > 0 {
+		log.Printf("Restart to apply: %s", strings.Join(restart, ", "))
 	}
 }
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/petertjmills/escpos-server/escpos"
+)
+
+// Metrics are the counters of GET /metrics, in the Prometheus text format.
+type Metrics struct {
+	jobs   counterVec // printer, status, content_type
+	bytes  counterVec // printer
+	paper  counterVec // printer, in millimetres
+	cuts   counterVec // printer
+	writes histogramVec
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{writes: histogramVec{buckets: writeBuckets}}
+}
+
+// Buckets of the write latency in seconds. Receipts take a few hundred
+// milliseconds, ones with images seconds.
+var writeBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// counterVec is a counter for every set of label values.
+type counterVec struct {
+	mu     sync.Mutex
+	values map[string]float64 // by the labels, like {printer="a"}
+}
+
+func (c *counterVec) Add(v float64, labels string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.values == nil {
+		c.values = map[string]float64{}
+	}
+	c.values[labels] += v
+}
+
+func (c *counterVec) write(w io.Writer, name, help string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeMetric(w, name, "counter", help, c.values)
+}
+
+// histogramVec is a histogram for every set of label values.
+type histogramVec struct {
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func (h *histogramVec) Observe(v float64, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.values == nil {
+		h.values = map[string]*histogram{}
+	}
+	hist, ok := h.values[labels]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[labels] = hist
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		hist.counts[i]++
+	}
+	hist.sum += v
+	hist.count++
+}
+
+func (h *histogramVec) write(w io.Writer, name, help string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for _, l := range sortedKeys(h.values) {
+		hist := h.values[l]
+		// The le label goes with the others inside the braces
+		prefix := strings.TrimSuffix(l, "}") + ","
+		var n uint64
+		for i, b := range h.buckets {
+			n += hist.counts[i]
+			fmt.Fprintf(w, "%s_bucket%sle=\"%s\"} %d\n", name, prefix, formatValue(b), n)
+		}
+		fmt.Fprintf(w, "%s_bucket%sle=\"+Inf\"} %d\n", name, prefix, hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, l, formatValue(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, l, hist.count)
+	}
+}
+
+// Writes a metric with a value for every set of labels.
+func writeMetric(w io.Writer, name, typ, help string, values map[string]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	for _, l := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s%s %s\n", name, l, formatValue(values[l]))
+	}
+}
+
+// Returns the labels of name and value pairs, like {printer="a"}.
+func labels(pairs ...string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", pairs[i], labelEscaper.Replace(pairs[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Writes the metrics of the jobs and printers.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m := s.metrics
+	m.jobs.write(w, "escpos_jobs_total", "Jobs by printer, status and content type.")
+	m.bytes.write(w, "escpos_bytes_sent_total", "Bytes written to printers.")
+	m.writes.write(w, "escpos_write_duration_seconds", "Time to write a job to the printer.")
+	m.paper.write(w, "escpos_paper_millimetres_total", "Estimated paper fed by printers.")
+	m.cuts.write(w, "escpos_cuts_total", "Cuts of the cutters of printers.")
+
+	queued := map[string]float64{}
+	reconnects := map[string]float64{}
+	up := map[string]float64{}
+	left := map[string]float64{}
+	flags := map[string]map[string]float64{"paper_low": {}, "paper_out": {}, "cover_open": {}}
+	for _, p := range s.printers {
+		st := p.Status()
+		l := labels("printer", p.Name)
+		queued[l] = float64(st.Queued)
+		if rb, ok := p.backend.(interface{ Reconnects() uint64 }); ok {
+			reconnects[l] = float64(rb.Reconnects())
+		}
+		// Unknown before the first job or status request
+		if st.Online != nil {
+			up[l] = boolValue(*st.Online)
+		}
+		if st.RollLeft != nil {
+			left[l] = *st.RollLeft
+		}
+		flags["paper_low"][l] = boolValue(st.PaperLow)
+		flags["paper_out"][l] = boolValue(st.PaperOut)
+		flags["cover_open"][l] = boolValue(st.CoverOpen)
+	}
+	writeMetric(w, "escpos_queue_depth", "gauge", "Jobs waiting in the queue of a printer.", queued)
+	writeMetric(w, "escpos_reconnects_total", "counter", "Times a USB printer was opened again after it was released.", reconnects)
+	writeMetric(w, "escpos_printer_up", "gauge", "Whether the last write or status request of a printer succeeded.", up)
+	writeMetric(w, "escpos_paper_left_millimetres", "gauge", "Estimated paper left on the roll of printers with a roll length.", left)
+	writeMetric(w, "escpos_printer_paper_low", "gauge", "Whether the paper is near its end, by the sensor of the printer or the estimate.", flags["paper_low"])
+	writeMetric(w, "escpos_printer_paper_out", "gauge", "Whether the printer reports it is out of paper.", flags["paper_out"])
+	writeMetric(w, "escpos_printer_cover_open", "gauge", "Whether the printer reports its cover is open.", flags["cover_open"])
+}
+
+// Records a write of a job to a printer.
+func (m *Metrics) written(p *Printer, n int, d time.Duration, paper escpos.Paper) {
+	l := labels("printer", p.Name)
+	m.bytes.Add(float64(n), l)
+	m.writes.Observe(d.Seconds(), labels("printer", p.Name, "backend", backendType(p.backend)))
+	m.paper.Add(paper.Millimetres(), l)
+	m.cuts.Add(float64(paper.Cuts), l)
+}
+
+// Returns the kind of a backend, like usb.
+func backendType(b Backend) string {
+	kind, _, _ := strings.Cut(b.String(), " ")
+	return kind
+}
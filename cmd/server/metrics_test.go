@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	_, ts := startServer(t, nil)
+
+	resp, err := http.Post(ts.URL+"/print", rawType, strings.NewReader("hello\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /print: got %s", resp.Status)
+	}
+
+	resp, err = http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type: got %q", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# HELP escpos_jobs_total Jobs by printer, status and content type.",
+		"# TYPE escpos_jobs_total counter",
+		`escpos_jobs_total{printer="p",status="done",content_type="application/octet-stream"} 1`,
+		"# TYPE escpos_bytes_sent_total counter",
+		`escpos_bytes_sent_total{printer="p"} 6`,
+		"# TYPE escpos_write_duration_seconds histogram",
+		`escpos_write_duration_seconds_bucket{printer="p",backend="file",le="+Inf"} 1`,
+		`escpos_write_duration_seconds_count{printer="p",backend="file"} 1`,
+		// A line feed at the default line spacing of 30 dots
+		`escpos_paper_millimetres_total{printer="p"} 3.75`,
+		`escpos_cuts_total{printer="p"} 0`,
+		"# TYPE escpos_queue_depth gauge",
+		`escpos_queue_depth{printer="p"} 0`,
+		`escpos_printer_up{printer="p"} 1`,
+		`escpos_printer_paper_out{printer="p"} 0`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("missing %s in:\n%s", line, body)
+		}
+	}
+}
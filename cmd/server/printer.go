@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/petertjmills/escpos-server/document"
+	"github.com/petertjmills/escpos-server/escpos"
+)
+
+// Job is what is printed: raw ESC/POS data, or a document that is rendered for
+// the profile of the printer it ends up on.
+type Job struct {
+	ID          uint64
+	Data        []byte
+	Doc         *document.Document
+	ContentType string
+
+	quota   *quota       // nil if nobody pays for its paper
+	state   atomic.Int32 // jobWaiting, jobPrinting or jobCancelled
+	started atomic.Int64 // Unix nanoseconds the printer took it
+}
+
+const (
+	jobWaiting int32 = iota
+	jobPrinting
+	jobCancelled
+)
+
+// Cancels the job if no printer took it yet.
+func (j *Job) Cancel() bool {
+	return j.state.CompareAndSwap(jobWaiting, jobCancelled)
+}
+
+// Returns the status of a job that didn't finish yet.
+func (j *Job) status() string {
+	switch j.state.Load() {
+	case jobPrinting:
+		return statusPrinting
+	case jobCancelled:
+		return statusCancelled
+	}
+	return statusQueued
+}
+
+// Target is where /print jobs go, a printer or a group of printers.
+type Target interface {
+	// Prints the job and returns the printer it was sent to and the number of
+	// bytes written.
+	Print(ctx context.Context, job *Job) (*Printer, int, error)
+}
+
+var (
+	errQueueFull  = errors.New("printer queue is full")
+	errInvalidJob = errors.New("invalid job")
+	errCancelled  = errors.New("job cancelled")
+	errShutdown   = errors.New("server is shutting down")
+)
+
+// Printer is a named printer with a queue of jobs that a worker writes to its
+// backend one at a time.
+type Printer struct {
+	Name    string
+	backend Backend
+	queue   chan *queued
+	events  *Events
+	metrics *Metrics
+	store   *JobStore
+	poll    time.Duration // between status requests, 0 to not ask
+	wg      sync.WaitGroup
+
+	stopMu   sync.RWMutex // held to queue a job, so none are queued after Stop
+	stopping bool
+	stopOnce sync.Once
+	stop     chan struct{} // closed by Stop
+	stopped  chan struct{} // closed when the worker is done and the backend closed
+
+	mu      sync.Mutex
+	profile string // name of config
+	config  escpos.PrinterConfig
+	roll    float64 // length of a paper roll in millimetres, 0 if unknown
+	nearEnd float64 // paper left on the roll that is near its end
+	online  bool    // the last write or status request succeeded
+	lastErr error
+	sensors sensors
+	usage   Usage
+}
+
+// sensors is what a printer reports about its paper and cover, or what the
+// estimate of the paper used says.
+type sensors struct {
+	PaperLow  bool `json:"paper_low"`
+	PaperOut  bool `json:"paper_out"`
+	CoverOpen bool `json:"cover_open"`
+}
+
+func (s sensors) problem() bool {
+	return s.PaperLow || s.PaperOut || s.CoverOpen
+}
+
+type queued struct {
+	job  *Job
+	data []byte
+	done chan result
+}
+
+type result struct {
+	n   int
+	err error
+}
+
+func newPrinter(name string, c *PrinterConfig, events *Events, metrics *Metrics, store *JobStore) *Printer {
+	p := &Printer{
+		Name:    name,
+		backend: newBackend(c),
+		queue:   make(chan *queued, c.Queue),
+		events:  events,
+		metrics: metrics,
+		store:   store,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if _, ok := p.backend.(statusBackend); ok && c.StatusInterval > 0 {
+		p.poll = c.StatusInterval
+	}
+	usage, err := store.GetUsage(name)
+	if err != nil {
+		log.Printf("Failed to read paper usage of printer %s: %v", name, err)
+	}
+	p.usage = usage
+	p.configure(c)
+	p.wg.Add(1)
+	go p.work()
+	return p
+}
+
+// Writes the queued jobs and, between them, asks the printer for its status.
+func (p *Printer) work() {
+	defer p.wg.Done()
+	var tick <-chan time.Time
+	if p.poll > 0 {
+		p.queryStatus()
+		t := time.NewTicker(p.poll)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		// Stopping goes before the queue, the jobs in it are kept
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+		select {
+		case <-p.stop:
+			return
+		case q := <-p.queue:
+			p.write(q)
+		case <-tick:
+			p.queryStatus()
+		}
+	}
+}
+
+func (p *Printer) write(q *queued) {
+	if !q.job.state.CompareAndSwap(jobWaiting, jobPrinting) {
+		q.done <- result{0, errCancelled}
+		return
+	}
+	q.job.started.Store(time.Now().UnixNano())
+	p.events.Publish(Event{Type: eventJobStarted, Printer: p.Name, Job: q.job.ID})
+	start := time.Now()
+	n, err := p.backend.Write(q.data)
+	// A job that failed part way is counted up to where it got
+	paper, _ := escpos.EstimatePaper(q.data[:n])
+	p.metrics.written(p, n, time.Since(start), paper)
+	p.update(func() {
+		p.setOnline(err)
+		p.addUsage(paper)
+	})
+	q.done <- result{n, err}
+}
+
+// Asks the printer for the offline cause and the paper sensor with DLE EOT 2
+// and 4.
+func (p *Printer) queryStatus() {
+	status, err := p.backend.(statusBackend).QueryStatus(2, 4)
+	if errors.Is(err, errNoStatus) {
+		return
+	}
+	if err != nil {
+		p.update(func() { p.setOnline(err) })
+		return
+	}
+	s, err := parseStatus(status[0], status[1])
+	if err != nil {
+		// Likely a stray byte, the next request tells
+		return
+	}
+	p.update(func() {
+		p.setOnline(nil)
+		p.sensors = s
+	})
+}
+
+// Reads the answers to DLE EOT 2 and 4.
+func parseStatus(offline, paper byte) (sensors, error) {
+	// Bits 1 and 4 are always set, 0 and 7 never
+	for _, b := range []byte{offline, paper} {
+		if b&0x93 != 0x12 {
+			return sensors{}, fmt.Errorf("unexpected status byte %#02x", b)
+		}
+	}
+	return sensors{
+		CoverOpen: offline&0x04 != 0,
+		PaperOut:  offline&0x20 != 0 || paper&0x60 != 0,
+		PaperLow:  paper&0x0c != 0,
+	}, nil
+}
+
+// Records whether the last write or status request failed. Needs p.mu.
+func (p *Printer) setOnline(err error) {
+	p.online, p.lastErr = err == nil, err
+}
+
+// Whether a write or status request was done yet. Needs p.mu.
+func (p *Printer) known() bool {
+	return p.online || p.lastErr != nil
+}
+
+// Returns what the sensors say, with the paper near its end if the estimate
+// says so. Needs p.mu.
+func (p *Printer) flags() sensors {
+	s := p.sensors
+	if left, ok := p.rollLeft(); ok && left <= p.nearEnd {
+		s.PaperLow = true
+	}
+	return s
+}
+
+// Changes the state of the printer with f, holding p.mu, and publishes what
+// changed.
+func (p *Printer) update(f func()) {
+	p.mu.Lock()
+	wasKnown, wasOnline, old := p.known(), p.online, p.flags()
+	f()
+	known, online, now := p.known(), p.online, p.flags()
+	p.mu.Unlock()
+
+	var types []string
+	if known && (!wasKnown || online != wasOnline) {
+		if online {
+			types = append(types, eventOnline)
+		} else {
+			types = append(types, eventOffline)
+		}
+	}
+	if now.PaperOut && !old.PaperOut {
+		types = append(types, eventPaperOut)
+	}
+	if now.PaperLow && !old.PaperLow {
+		types = append(types, eventPaperLow)
+	}
+	if now.CoverOpen && !old.CoverOpen {
+		types = append(types, eventCoverOpen)
+	}
+	if old.problem() && !now.problem() {
+		types = append(types, eventReady)
+	}
+	if len(types) == 0 {
+		return
+	}
+	status := p.Status()
+	for _, t := range types {
+		p.events.Publish(Event{Type: t, Printer: p.Name, Status: &status})
+	}
+}
+
+// Queues the job and waits until it is written. If ctx is done first the job
+// is cancelled, unless it is printing already. The quota of the job is
+// charged with the paper of what it renders to on p, and a job that fails
+// gets back what wasn't printed.
+func (p *Printer) Print(ctx context.Context, job *Job) (*Printer, int, error) {
+	data, err := p.render(job)
+	if err != nil {
+		return p, 0, fmt.Errorf("%w: %v", errInvalidJob, err)
+	}
+	if job.quota != nil {
+		if err := job.quota.charge(data); err != nil {
+			return p, 0, err
+		}
+	}
+	n, err := p.wait(ctx, &queued{job: job, data: data, done: make(chan result, 1)})
+	if err != nil && job.quota != nil {
+		job.quota.refund(data, n)
+	}
+	return p, n, err
+}
+
+func (p *Printer) wait(ctx context.Context, q *queued) (int, error) {
+	if err := p.enqueue(q); err != nil {
+		return 0, err
+	}
+	select {
+	case r := <-q.done:
+		return r.n, r.err
+	case <-ctx.Done():
+		if q.job.Cancel() {
+			return 0, ctx.Err()
+		}
+		r := <-q.done
+		return r.n, r.err
+	}
+}
+
+func (p *Printer) enqueue(q *queued) error {
+	p.stopMu.RLock()
+	defer p.stopMu.RUnlock()
+	if p.stopping {
+		return errShutdown
+	}
+	select {
+	case p.queue <- q:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// Sets the settings of a printer that can change while it runs: its profile
+// and paper roll. A new roll length can make the paper low.
+func (p *Printer) configure(c *PrinterConfig) {
+	p.update(func() {
+		p.profile, p.config = c.Profile, c.profile
+		p.roll, p.nearEnd = c.RollLength*1000, c.NearEnd*1000
+	})
+}
+
+// Returns the ESC/POS data of a job.
+func (p *Printer) render(job *Job) ([]byte, error) {
+	if job.Doc == nil {
+		return job.Data, nil
+	}
+	p.mu.Lock()
+	config := p.config
+	p.mu.Unlock()
+	var buf bytes.Buffer
+	e := escpos.New(&buf)
+	e.SetConfig(config)
+	if err := job.Doc.Render(e, document.WithoutImageFiles()); err != nil {
+		return nil, err
+	}
+	if err := e.Print(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Stops taking jobs, waits for the job being written and closes the backend.
+// Jobs still queued fail with errShutdown, to be printed after a restart. If
+// ctx is done first Stop returns, leaving the write to the exit.
+func (p *Printer) Stop(ctx context.Context) error {
+	p.stopOnce.Do(func() {
+		p.stopMu.Lock()
+		p.stopping = true
+		p.stopMu.Unlock()
+		close(p.stop)
+		go func() {
+			p.wg.Wait()
+			// Nothing is queued after stopping, so this empties the queue
+			for len(p.queue) > 0 {
+				q := <-p.queue
+				q.done <- result{0, errShutdown}
+			}
+			if err := p.backend.Close(); err != nil {
+				log.Printf("Failed to close printer %s: %v", p.Name, err)
+			}
+			close(p.stopped)
+		}()
+	})
+	select {
+	case <-p.stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("printer %s is still writing a job: %w", p.Name, ctx.Err())
+	}
+}
+
+// PrinterStatus is the state of a printer for GET /printers.
+type PrinterStatus struct {
+	Name    string `json:"name"`
+	Backend string `json:"backend"`
+	Profile string `json:"profile"`
+	Online  *bool  `json:"online"` // unknown before the first job
+	Queued  int    `json:"queued"`
+	Error   string `json:"error,omitempty"`
+	sensors
+	Usage    Usage    `json:"usage"`
+	RollLeft *float64 `json:"roll_left_mm,omitempty"` // without a roll length unknown
+}
+
+func (p *Printer) Status() PrinterStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := PrinterStatus{
+		Name:    p.Name,
+		Backend: p.backend.String(),
+		Profile: p.profile,
+		Queued:  len(p.queue),
+		sensors: p.flags(),
+		Usage:   p.usage,
+	}
+	if left, ok := p.rollLeft(); ok {
+		s.RollLeft = &left
+	}
+	if p.online || p.lastErr != nil {
+		online := p.online
+		s.Online = &online
+	}
+	if p.lastErr != nil {
+		s.Error = p.lastErr.Error()
+	}
+	return s
+}
+
+// Group shares jobs between printers. Failover tries the printers in order
+// and round-robin starts with the next printer for every job; both move on
+// to the next printer when one fails.
+type Group struct {
+	Name     string
+	Mode     string
+	Printers []*Printer
+	next     atomic.Uint64
+}
+
+func (g *Group) Print(ctx context.Context, job *Job) (*Printer, int, error) {
+	start := 0
+	if g.Mode == "round-robin" {
+		start = int(g.next.Add(1)-1) % len(g.Printers)
+	}
+	var errs []error
+	for i := range g.Printers {
+		p := g.Printers[(start+i)%len(g.Printers)]
+		_, n, err := p.Print(ctx, job)
+		if err == nil {
+			return p, n, nil
+		}
+		// A job partly written isn't tried again so it can't print twice
+		var qe *quotaError
+		if ctx.Err() != nil || n > 0 || errors.Is(err, errInvalidJob) || errors.Is(err, errCancelled) || errors.Is(err, errShutdown) || errors.As(err, &qe) {
+			return p, n, err
+		}
+		// Waiting for the next printer
+		job.state.CompareAndSwap(jobPrinting, jobWaiting)
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name, err))
+	}
+	return nil, 0, errors.Join(errs...)
+}
+
+// GroupStatus is the state of a group for GET /printers.
+type GroupStatus struct {
+	Name     string   `json:"name"`
+	Mode     string   `json:"mode"`
+	Printers []string `json:"printers"`
+}
+
+func (g *Group) Status() GroupStatus {
+	s := GroupStatus{Name: g.Name, Mode: g.Mode}
+	for _, p := range g.Printers {
+		s.Printers = append(s.Printers, p.Name)
+	}
+	return s
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/petertjmills/escpos-server/escpos"
+)
+
+// settings are what a reload changes while the server runs.
+type settings struct {
+	config    *Config // the config they are from
+	fallback  string  // target of /print
+	auth      *Auth   // nil without a tokens file
+	limits    Limits
+	keyWindow time.Duration // how long idempotency keys are remembered
+}
+
+// Returns the settings of a config. The tokens of old are kept if the
+// tokens file is the same, they are read again when it changes anyway.
+func newSettings(c *Config, old *settings) (*settings, error) {
+	st := &settings{
+		config:    c,
+		fallback:  c.Default,
+		limits:    c.Limits,
+		keyWindow: c.History.IdempotencyWindow,
+	}
+	switch {
+	case c.Tokens == "":
+	case old != nil && old.auth != nil && old.config.Tokens == c.Tokens:
+		st.auth = old.auth
+	default:
+		auth, err := newAuth(c.Tokens)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tokens: %w", err)
+		}
+		st.auth = auth
+	}
+	return st, nil
+}
+
+// Applies a config that was loaded again: the default printer, tokens,
+// limits, the idempotency window, request logging and the profiles and
+// paper rolls of printers. Returns the settings that changed but only apply
+// after a restart. Nothing is applied if c can't be.
+func (s *Server) Reload(c *Config) ([]string, error) {
+	old := s.settings.Load()
+	if s.target(c.Default) == nil {
+		return nil, fmt.Errorf("default: printer or group %q isn't running, restart to add it", c.Default)
+	}
+	st, err := newSettings(c, old)
+	if err != nil {
+		return nil, err
+	}
+	for name, p := range s.printers {
+		if pc, ok := c.Printers[name]; ok {
+			p.configure(pc)
+		}
+	}
+	s.settings.Store(st)
+	return restartNeeded(old.config, c), nil
+}
+
+// Returns the settings that differ between the configs and can't change
+// while the server runs.
+func restartNeeded(old, c *Config) []string {
+	var changed []string
+	if old.Listen != c.Listen {
+		changed = append(changed, "listen")
+	}
+	if !reflect.DeepEqual(old.TLS, c.TLS) {
+		changed = append(changed, "tls")
+	}
+	if old.History.Path != c.History.Path || old.History.Size != c.History.Size {
+		changed = append(changed, "history")
+	}
+	if old.Limits.ReadTimeout != c.Limits.ReadTimeout {
+		changed = append(changed, "limits.read_timeout")
+	}
+	if !reflect.DeepEqual(old.Groups, c.Groups) {
+		changed = append(changed, "groups")
+	}
+	for _, name := range sortedKeys(old.Printers) {
+		if p, ok := c.Printers[name]; !ok || !sameBackend(old.Printers[name], p) {
+			changed = append(changed, "printers."+name)
+		}
+	}
+	for _, name := range sortedKeys(c.Printers) {
+		if _, ok := old.Printers[name]; !ok {
+			changed = append(changed, "printers."+name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Reports whether the printers only differ in what configure changes.
+func sameBackend(a, b *PrinterConfig) bool {
+	x, y := *a, *b
+	for _, p := range []*PrinterConfig{&x, &y} {
+		p.Profile, p.profile, p.RollLength, p.NearEnd = "", escpos.PrinterConfig{}, 0, 0
+	}
+	return x == y
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/petertjmills/escpos-server/document"
+	"github.com/petertjmills/escpos-server/escpos"
+)
+
+// Server routes print jobs to the configured printers and groups.
+type Server struct {
+	printers map[string]*Printer
+	groups   map[string]*Group
+	jobs     *JobStore
+	events   *Events
+	metrics  *Metrics
+
+	settings atomic.Pointer[settings] // replaced by Reload
+	closing  atomic.Bool              // no jobs are taken while shutting down
+
+	mu      sync.Mutex
+	active  map[uint64]*Job          // jobs that didn't finish, for cancelling them
+	running map[string]chan struct{} // idempotency keys of running requests
+}
+
+func NewServer(c *Config) (*Server, error) {
+	s := &Server{
+		printers: map[string]*Printer{},
+		groups:   map[string]*Group{},
+		active:   map[uint64]*Job{},
+		running:  map[string]chan struct{}{},
+		events:   newEvents(),
+		metrics:  newMetrics(),
+	}
+	jobs, err := openJobStore(c.History.Path, c.History.Size)
+	if err != nil {
+		return nil, err
+	}
+	s.jobs = jobs
+	for name, pc := range c.Printers {
+		s.printers[name] = newPrinter(name, pc, s.events, s.metrics, s.jobs)
+	}
+	for name, gc := range c.Groups {
+		g := &Group{Name: name, Mode: gc.Mode}
+		for _, member := range gc.Printers {
+			g.Printers = append(g.Printers, s.printers[member])
+		}
+		s.groups[name] = g
+	}
+	st, err := newSettings(c, nil)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.settings.Store(st)
+
+	held, err := s.jobs.Held()
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to read held jobs: %w", err)
+	}
+	if len(held) > 0 {
+		log.Printf("Printing %d jobs held at the last shutdown", len(held))
+		go s.resume(held)
+	}
+	return s, nil
+}
+
+// Returns the printer or group with the name.
+func (s *Server) target(name string) Target {
+	if name == "" {
+		name = s.settings.Load().fallback
+	}
+	if p, ok := s.printers[name]; ok {
+		return p
+	}
+	if g, ok := s.groups[name]; ok {
+		return g
+	}
+	return nil
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /print", s.require(scopePrint, s.handlePrint))
+	mux.HandleFunc("POST /printers/{name}/print", s.require(scopePrint, s.handlePrint))
+	mux.HandleFunc("POST /printers/{name}/preview", s.require(scopePreview, s.handlePreview))
+	mux.HandleFunc("POST /printers/{name}/drawer", s.require(scopeDrawer, s.handleDrawer))
+	mux.HandleFunc("POST /printers/{name}/roll-replaced", s.require(scopeAdmin, s.handleRollReplaced))
+	mux.HandleFunc("GET /printers", s.require("", s.handlePrinters))
+	mux.HandleFunc("GET /events", tokenParam(s.require("", s.handleEvents)))
+	mux.HandleFunc("GET /metrics", s.require("", s.handleMetrics))
+	mux.HandleFunc("GET /jobs", s.require(scopePrint, s.handleJobs))
+	mux.HandleFunc("DELETE /jobs/{id}", s.require(scopePrint, s.handleCancel))
+	mux.HandleFunc("POST /jobs/{id}/reprint", s.require(scopePrint, s.handleReprint))
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "OK")
+	})
+	return s.logRequests(mux)
+}
+
+func (s *Server) handlePrint(w http.ResponseWriter, r *http.Request) {
+	target := s.target(r.PathValue("name"))
+	if target == nil {
+		http.Error(w, fmt.Sprintf("Unknown printer %q", r.PathValue("name")), http.StatusNotFound)
+		return
+	}
+	job, ok := s.readJob(w, r)
+	if !ok {
+		return
+	}
+	s.print(w, r, r.PathValue("name"), job, 0)
+}
+
+// printResult is the response to a print request, kept for its
+// idempotency key.
+type printResult struct {
+	Code       int    `json:"code"`
+	Body       string `json:"body"`
+	JobID      uint64 `json:"job_id,omitempty"`
+	RetryAfter int    `json:"-"`    // seconds
+	Printed    bool   `json:"-"`    // the printer may have printed some of the job, or will
+	Hash       string `json:"hash"` // of the request the key was used for
+}
+
+func (res *printResult) write(w http.ResponseWriter) {
+	if res.JobID != 0 {
+		w.Header().Set("X-Job-ID", strconv.FormatUint(res.JobID, 10))
+	}
+	if res.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(res.RetryAfter))
+	}
+	if res.Code != http.StatusOK {
+		http.Error(w, res.Body, res.Code)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, res.Body)
+}
+
+// Prints the job on the printer or group with the name and writes the
+// response. A request with an Idempotency-Key gets the response of the
+// earlier request with the key instead, if there is one.
+func (s *Server) print(w http.ResponseWriter, r *http.Request, name string, job *Job, reprintOf uint64) {
+	name = cmp.Or(name, s.settings.Load().fallback)
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		s.runJob(r, name, job, reprintOf).write(w)
+		return
+	}
+	s.idempotent(w, r, key, requestHash(name, job, reprintOf), func() *printResult {
+		return s.runJob(r, name, job, reprintOf)
+	})
+}
+
+// Prints the job and keeps a record of it.
+func (s *Server) runJob(r *http.Request, name string, job *Job, reprintOf uint64) *printResult {
+	if s.closing.Load() {
+		return &printResult{Code: http.StatusServiceUnavailable, Body: "Server is shutting down", RetryAfter: 10}
+	}
+	target := s.target(name)
+	if target == nil {
+		return &printResult{Code: http.StatusNotFound, Body: fmt.Sprintf("Unknown printer %q", name)}
+	}
+
+	size := len(job.Data)
+	// A reload can remove the tokens file while the request runs
+	if token, auth := tokenFrom(r.Context()), s.settings.Load().auth; token != nil && auth != nil {
+		job.quota = &quota{auth: auth, token: token}
+	}
+
+	rec := &JobRecord{
+		Target:      name,
+		Client:      clientOf(r),
+		ContentType: job.ContentType,
+		Size:        size,
+		Status:      statusQueued,
+		ReprintOf:   reprintOf,
+		Created:     time.Now(),
+	}
+	// A job is printed even if the history fails
+	if err := s.jobs.Add(rec, job.Data); err != nil {
+		log.Printf("Failed to add job to history: %v", err)
+	} else {
+		job.ID = rec.ID
+		s.mu.Lock()
+		s.active[job.ID] = job
+		s.mu.Unlock()
+	}
+	s.events.Publish(Event{Type: eventJobQueued, Job: job.ID, Target: name})
+
+	p, n, err := target.Print(r.Context(), job)
+	s.finish(rec, job, p, err)
+	res := &printResult{JobID: job.ID, Printed: err == nil || n > 0}
+	var qe *quotaError
+	switch {
+	case errors.As(err, &qe):
+		res.Code, res.Body = http.StatusTooManyRequests, fmt.Sprintf("Daily quota of %d mm of paper exceeded", qe.limit)
+		res.RetryAfter = int(qe.wait.Seconds()) + 1
+	case errors.Is(err, errCancelled) || (n == 0 && errors.Is(err, context.Canceled)):
+		res.Code, res.Body = http.StatusConflict, fmt.Sprintf("Job %d was cancelled", job.ID)
+	case errors.Is(err, errInvalidJob):
+		res.Code, res.Body = http.StatusBadRequest, fmt.Sprintf("Invalid document: %v", err)
+	case errors.Is(err, errShutdown) && job.ID != 0:
+		res.Code, res.Body = http.StatusAccepted, fmt.Sprintf("Server is shutting down, job %d will be printed after the restart", job.ID)
+		res.Printed = true
+	case errors.Is(err, errShutdown):
+		res.Code, res.Body, res.RetryAfter = http.StatusServiceUnavailable, "Server is shutting down", 10
+	case errors.Is(err, errQueueFull):
+		res.Code, res.Body = http.StatusServiceUnavailable, "Printer is busy"
+	case err != nil:
+		res.Code, res.Body = http.StatusInternalServerError, fmt.Sprintf("Failed to write to printer: %v", err)
+	default:
+		res.Code, res.Body = http.StatusOK, fmt.Sprintf("Successfully sent %d bytes to printer %s", n, p.Name)
+	}
+	return res
+}
+
+// Returns the ESC/POS data a job renders to on a printer, without printing
+// it. A group renders for its first printer.
+func (s *Server) handlePreview(w http.ResponseWriter, r *http.Request) {
+	var p *Printer
+	switch t := s.target(r.PathValue("name")).(type) {
+	case *Printer:
+		p = t
+	case *Group:
+		p = t.Printers[0]
+	default:
+		http.Error(w, fmt.Sprintf("Unknown printer %q", r.PathValue("name")), http.StatusNotFound)
+		return
+	}
+	job, ok := s.readJob(w, r)
+	if !ok {
+		return
+	}
+	data, err := p.render(job)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid document: %v", err), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+// Opens the cash drawer on pin 2, or the pin of the pin query parameter.
+func (s *Server) handleDrawer(w http.ResponseWriter, r *http.Request) {
+	target := s.target(r.PathValue("name"))
+	if target == nil {
+		http.Error(w, fmt.Sprintf("Unknown printer %q", r.PathValue("name")), http.StatusNotFound)
+		return
+	}
+	pin := uint8(2)
+	switch r.URL.Query().Get("pin") {
+	case "", "2":
+	case "5":
+		pin = 5
+	default:
+		http.Error(w, "The pin must be 2 or 5", http.StatusBadRequest)
+		return
+	}
+	var buf bytes.Buffer
+	e := escpos.New(&buf)
+	e.OpenDrawer(pin)
+	e.Print()
+	s.print(w, r, r.PathValue("name"), &Job{Data: buf.Bytes(), ContentType: rawType}, 0)
+}
+
+// Reads the job of a request, writing an error response if it is invalid.
+func (s *Server) readJob(w http.ResponseWriter, r *http.Request) (*Job, bool) {
+	limits := s.settings.Load().limits
+	// Read the raw data from the request body
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limits.MaxBody))
+	if maxErr := (*http.MaxBytesError)(nil); errors.As(err, &maxErr) {
+		http.Error(w, fmt.Sprintf("Request body is larger than %d bytes", maxErr.Limit), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return nil, false
+	}
+	defer r.Body.Close()
+
+	// Structured documents are rendered to ESC/POS by the printer they go to
+	job := &Job{Data: data, ContentType: rawType}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); documentTypes[mediaType] {
+		job.ContentType = mediaType
+		job.Doc, err = decodeDocument(mediaType, data)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid document: %v", err), http.StatusBadRequest)
+			return nil, false
+		}
+		return job, true
+	}
+
+	// Whatever the validation, only the drawer scope opens the drawer
+	token := tokenFrom(r.Context())
+	if token != nil && !token.Allows(scopeDrawer) && hasDrawer(data) {
+		http.Error(w, fmt.Sprintf("The job opens the cash drawer and token %s lacks the %s scope", token.Name, scopeDrawer), http.StatusForbidden)
+		return nil, false
+	}
+	if job.Data, err = s.validate(data, token); err != nil {
+		http.Error(w, fmt.Sprintf("Rejected ESC/POS data: %v", err), http.StatusBadRequest)
+		return nil, false
+	}
+	return job, true
+}
+
+// Checks a raw job with the validation of the token, or of the limits if the
+// token has none.
+func (s *Server) validate(data []byte, token *Token) ([]byte, error) {
+	mode := s.settings.Load().limits.Validation
+	if token != nil && token.Validation != "" {
+		mode = token.Validation
+	}
+	return validate(data, mode, token)
+}
+
+func (s *Server) handlePrinters(w http.ResponseWriter, r *http.Request) {
+	resp := struct {
+		Default  string          `json:"default"`
+		Printers []PrinterStatus `json:"printers"`
+		Groups   []GroupStatus   `json:"groups"`
+	}{Default: s.settings.Load().fallback, Printers: []PrinterStatus{}, Groups: []GroupStatus{}}
+	for _, p := range s.printers {
+		resp.Printers = append(resp.Printers, p.Status())
+	}
+	for _, g := range s.groups {
+		resp.Groups = append(resp.Groups, g.Status())
+	}
+	sort.Slice(resp.Printers, func(i, j int) bool { return resp.Printers[i].Name < resp.Printers[j].Name })
+	sort.Slice(resp.Groups, func(i, j int) bool { return resp.Groups[i].Name < resp.Groups[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Content type of raw ESC/POS jobs.
+const rawType = "application/octet-stream"
+
+// Content types of receipt documents accepted by /print besides raw ESC/POS.
+var documentTypes = map[string]bool{
+	"application/json":   true,
+	"application/yaml":   true,
+	"application/x-yaml": true,
+	"text/yaml":          true,
+}
+
+// Decodes a JSON or YAML receipt document.
+func decodeDocument(mediaType string, data []byte) (*document.Document, error) {
+	if mediaType == "application/json" {
+		return document.DecodeJSON(data)
+	}
+	return document.DecodeYAML(data)
+}
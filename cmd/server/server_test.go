@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/petertjmills/escpos-server/document"
+	"github.com/petertjmills/escpos-server/escpos"
+)
+
+// Starts a server with the file printer p, the config changed by configure
+// if it isn't nil and, if there are tokens, a tokens file with them. The
+// secret of every token is its name.
+func startServer(t *testing.T, configure func(c *Config, dir string), tokens ...*Token) (*Server, *httptest.Server) {
+	t.Helper()
+	dir := t.TempDir()
+	c := &Config{
+		Printers: map[string]*PrinterConfig{
+			"p": {Backend: "file", Path: filepath.Join(dir, "p.out")},
+		},
+		History: History{Path: filepath.Join(dir, "jobs.db")},
+	}
+	if configure != nil {
+		configure(c, dir)
+	}
+	if len(tokens) > 0 {
+		for _, tok := range tokens {
+			tok.Hash = hashToken(tok.Name)
+		}
+		c.Tokens = filepath.Join(dir, "tokens.yaml")
+		if err := (&TokenFile{Tokens: tokens}).save(c.Tokens); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+// Sends a request with the token and returns the status and body of the
+// response.
+func request(t *testing.T, ts *httptest.Server, token, method, path, contentType string, body []byte) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+// Returns what the printer p of startServer was sent.
+func printed(t *testing.T, s *Server) []byte {
+	t.Helper()
+	data, err := os.ReadFile(s.settings.Load().config.Printers["p"].Path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestPrintNeedsDrawerScope(t *testing.T) {
+	s, ts := startServer(t, nil,
+		&Token{Name: "till", Scopes: []string{scopePrint}},
+		&Token{Name: "cashier", Scopes: []string{scopePrint, scopeDrawer}},
+	)
+	escP := []byte{0x1b, 'p', 0, 25, 250}
+	dleDC4 := []byte{0x10, 0x14, 1, 0, 1}
+	for _, tt := range []struct {
+		token       string
+		contentType string
+		data        []byte
+		want        int
+	}{
+		{"till", rawType, []byte("hello\n"), http.StatusOK},
+		{"till", rawType, escP, http.StatusForbidden},
+		{"till", rawType, dleDC4, http.StatusForbidden},
+		{"till", rawType, append([]byte("hello\n"), escP...), http.StatusForbidden},
+		{"till", "application/json", []byte(`{"blocks": [{"text": {"content": "hi"}}]}`), http.StatusOK},
+		{"cashier", rawType, escP, http.StatusOK},
+		{"cashier", rawType, dleDC4, http.StatusOK},
+	} {
+		code, body := request(t, ts, tt.token, http.MethodPost, "/printers/p/print", tt.contentType, tt.data)
+		if code != tt.want {
+			t.Errorf("%s % x: got %d %s, want %d", tt.token, tt.data, code, body, tt.want)
+		}
+	}
+	if out := printed(t, s); bytes.Count(out, escP) != 1 || bytes.Count(out, dleDC4) != 1 {
+		t.Errorf("printed % x, want the drawer pulses of cashier only", out)
+	}
+}
+
+func TestDailyPaper(t *testing.T) {
+	_, ts := startServer(t, nil, &Token{Name: "till", Scopes: []string{scopePrint}, DailyPaper: 10})
+	// A line feed is 3.75 mm of paper
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		code, body := request(t, ts, "till", http.MethodPost, "/printers/p/print", rawType, []byte("hello\n"))
+		if code != want {
+			t.Errorf("job %d: got %d %s, want %d", i+1, code, body, want)
+		}
+	}
+}
+
+// A group charges the paper of the printer that takes the job, rendered for
+// its paper width.
+func TestDailyPaperOfGroup(t *testing.T) {
+	s, ts := startServer(t, func(c *Config, dir string) {
+		c.Profiles = map[string]*ProfileConfig{"narrow": {DotsPerLine: 384}}
+		c.Printers["broken"] = &PrinterConfig{Backend: "file", Path: filepath.Join(dir, "missing", "broken.out")}
+		c.Printers["q"] = &PrinterConfig{Backend: "file", Path: filepath.Join(dir, "q.out"), Profile: "narrow"}
+		c.Groups = map[string]*GroupConfig{"g": {Printers: []string{"broken", "q"}}}
+		c.Default = "p"
+	}, &Token{Name: "till", Scopes: []string{scopePrint}, DailyPaper: 1000})
+
+	doc := []byte(`{"blocks": [{"text": {"content": "` + strings.Repeat("word ", 40) + `"}}]}`)
+	code, body := request(t, ts, "till", http.MethodPost, "/printers/g/print", "application/json", doc)
+	if code != http.StatusOK || !strings.Contains(body, "printer q") {
+		t.Fatalf("got %d %s, want it printed on q", code, body)
+	}
+	out, err := os.ReadFile(s.settings.Load().config.Printers["q"].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := escpos.EstimatePaper(out)
+	wide, _ := s.printers["p"].render(&Job{Doc: mustDecode(t, doc)})
+	if paper, _ := escpos.EstimatePaper(wide); paper == want {
+		t.Fatalf("the document takes as much paper on p as on q, %v", paper)
+	}
+	if got := s.settings.Load().auth.usage["till"].paper; got != want.Millimetres() {
+		t.Errorf("charged %v mm, want the %v mm printed on q", got, want.Millimetres())
+	}
+}
+
+func mustDecode(t *testing.T, data []byte) *document.Document {
+	t.Helper()
+	doc, err := document.DecodeJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestRollReplacedNeedsAdmin(t *testing.T) {
+	_, ts := startServer(t, nil,
+		&Token{Name: "till", Scopes: []string{scopePrint, scopeDrawer}},
+		&Token{Name: "manager", Scopes: []string{scopeAdmin}},
+	)
+	for _, tt := range []struct {
+		token string
+		want  int
+	}{
+		{"till", http.StatusForbidden},
+		{"manager", http.StatusOK},
+	} {
+		if code, body := request(t, ts, tt.token, http.MethodPost, "/printers/p/roll-replaced", "", nil); code != tt.want {
+			t.Errorf("%s: got %d %s, want %d", tt.token, code, body, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+const systemdService = `[Unit]
+Description=ESC/POS USB Printer Server
+After=network.target
+
+[Service]
+Type=simple
+User=%s
+WorkingDirectory=%s
+ExecStart=%s -config %s
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`
+
+const configPath = "/etc/escpos-server/config.yaml"
+
+// Config file written by install-service, for the printer the server used
+// to be started with.
+const defaultConfig = `# Settings of escpos-server, see the README. Reload with
+# systemctl reload escpos-server after changing them.
+listen: ":8080"
+printers:
+  default:
+    backend: usb
+    vendor: 0x04b8
+    product: 0x0e15
+`
+
+func installService() {
+	// Check if running as root
+	if os.Geteuid() != 0 {
+		log.Fatal("install-service must be run as root (use sudo)")
+	}
+
+	// Get the user who ran sudo
+	currentUser := os.Getenv("SUDO_USER")
+	if currentUser == "" {
+		log.Fatal("Could not determine the original user. Make sure to run with sudo.")
+	}
+
+	execPath, err := os.Executable()
+	if err != nil {
+		log.Fatalf("Failed to get executable path: %v", err)
+	}
+	execPath, _ = filepath.EvalSymlinks(execPath)
+	workingDir := filepath.Dir(execPath)
+
+	// Blacklist usblp module
+	blacklistContent := "# Blacklist usblp module to allow direct USB printer access\nblacklist usblp\n"
+	if err := os.WriteFile("/etc/modprobe.d/blacklist-usblp.conf", []byte(blacklistContent), 0644); err != nil {
+		log.Fatalf("Failed to write blacklist file: %v", err)
+	}
+	fmt.Println("Blacklisted usblp module")
+
+	// Remove usblp if currently loaded
+	exec.Command("rmmod", "usblp").Run() // Ignore errors
+
+	// Keep the config of an earlier install
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+			log.Fatalf("Failed to create config directory: %v", err)
+		}
+		if err := os.WriteFile(configPath, []byte(defaultConfig), 0644); err != nil {
+			log.Fatalf("Failed to write config file: %v", err)
+		}
+		fmt.Println("Config file written to", configPath)
+	}
+
+	serviceContent := fmt.Sprintf(systemdService, currentUser, workingDir, execPath, configPath)
+	servicePath := "/etc/systemd/system/escpos-server.service"
+
+	// Write the service file
+	if err := os.WriteFile(servicePath, []byte(serviceContent), 0644); err != nil {
+		log.Fatalf("Failed to write service file: %v", err)
+	}
+	fmt.Println("Systemd service file written to", servicePath)
+
+	// ... rest of the systemctl commands
+}
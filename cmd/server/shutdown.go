@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Stops taking jobs, lets the printers finish the jobs they are writing and
+// keeps the queued jobs to print after the restart. Then waits for the
+// requests of hs, if given, and closes the job history. When ctx is done
+// first, printers that are still writing are left to the exit.
+func (s *Server) Shutdown(ctx context.Context, hs *http.Server) error {
+	s.closing.Store(true)
+	// Event streams never end by themselves
+	s.events.Close()
+
+	errs := make(chan error, len(s.printers))
+	for _, p := range s.printers {
+		go func() { errs <- p.Stop(ctx) }()
+	}
+	var all []error
+	for range s.printers {
+		if err := <-errs; err != nil {
+			all = append(all, err)
+		}
+	}
+	if hs != nil {
+		if err := hs.Shutdown(ctx); err != nil {
+			all = append(all, fmt.Errorf("requests still running: %w", err))
+		}
+	}
+	if s.jobs != nil {
+		if err := s.jobs.Close(); err != nil {
+			all = append(all, err)
+		}
+	}
+	return errors.Join(all...)
+}
+
+// Closes the printers after the jobs they are writing and the job history.
+func (s *Server) Close() {
+	if err := s.Shutdown(context.Background(), nil); err != nil {
+		log.Printf("Failed to close server: %v", err)
+	}
+}
+
+// Prints the jobs held at the last shutdown, one after the other in the order
+// they came in.
+func (s *Server) resume(recs []*JobRecord) {
+	for _, rec := range recs {
+		rec, data, err := s.jobs.Get(rec.ID)
+		if err != nil {
+			log.Printf("Failed to read held job: %v", err)
+			continue
+		}
+		job := &Job{ID: rec.ID, Data: data, ContentType: rec.ContentType}
+		s.mu.Lock()
+		s.active[job.ID] = job
+		s.mu.Unlock()
+
+		target := s.target(rec.Target)
+		switch {
+		case target == nil:
+			err = fmt.Errorf("unknown printer %q", rec.Target)
+		case documentTypes[rec.ContentType]:
+			if job.Doc, err = decodeDocument(rec.ContentType, data); err != nil {
+				err = fmt.Errorf("%w: %v", errInvalidJob, err)
+			}
+		}
+		var p *Printer
+		if err == nil {
+			rec.Status = statusQueued
+			if err := s.jobs.Update(rec); err != nil {
+				log.Printf("Failed to update job %d in history: %v", rec.ID, err)
+			}
+			s.events.Publish(Event{Type: eventJobQueued, Job: job.ID, Target: rec.Target})
+			p, _, err = target.Print(context.Background(), job)
+		}
+		s.finish(rec, job, p, err)
+	}
+}
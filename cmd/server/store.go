@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Statuses of a job record.
+const (
+	statusQueued    = "queued"
+	statusPrinting  = "printing"
+	statusDone      = "done"
+	statusFailed    = "failed"
+	statusCancelled = "cancelled"
+	statusHeld      = "held" // kept at a shutdown, to print after the restart
+)
+
+// JobRecord is the history entry of a job.
+type JobRecord struct {
+	ID          uint64     `json:"id"`
+	Target      string     `json:"target"`            // printer or group the job was sent to
+	Printer     string     `json:"printer,omitempty"` // printer that took it
+	Client      string     `json:"client"`            // token name, certificate name or address
+	ContentType string     `json:"content_type"`
+	Size        int        `json:"size"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	ReprintOf   uint64     `json:"reprint_of,omitempty"`
+	Created     time.Time  `json:"created"`
+	Started     *time.Time `json:"started,omitempty"`
+	Finished    *time.Time `json:"finished,omitempty"`
+}
+
+var (
+	jobsBucket  = []byte("jobs")
+	dataBucket  = []byte("data")  // job data by the same keys, for reprints
+	keysBucket  = []byte("keys")  // idempotency keys
+	usageBucket = []byte("usage") // paper usage by printer
+)
+
+// keyRecord is the response to the request with an idempotency key.
+type keyRecord struct {
+	Result *printResult `json:"result"`
+	Time   time.Time    `json:"time"`
+}
+
+var errNoJob = errors.New("no such job")
+
+// JobStore is the bounded job history, in a bbolt database. The oldest jobs
+// are removed when there are more than size.
+type JobStore struct {
+	db   *bolt.DB
+	size int
+}
+
+// Opens the history at path. Jobs that were queued or printing when the
+// server stopped without shutting down are marked as failed.
+func openJobStore(path string, size int) (*JobStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job history %s: %w", path, err)
+	}
+	s := &JobStore{db: db, size: size}
+	err = db.Update(func(tx *bolt.Tx) error {
+		jobs, err := tx.CreateBucketIfNotExists(jobsBucket)
+		if err != nil {
+			return err
+		}
+		for _, name := range [][]byte{dataBucket, keysBucket, usageBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return jobs.ForEach(func(k, v []byte) error {
+			var rec JobRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			if rec.Status != statusQueued && rec.Status != statusPrinting {
+				return nil
+			}
+			rec.Status, rec.Error = statusFailed, "the server stopped"
+			return putJSON(jobs, k, &rec)
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open job history %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *JobStore) Close() error {
+	return s.db.Close()
+}
+
+// Adds a record and the data of its job, setting the ID of the record.
+func (s *JobStore) Add(rec *JobRecord, data []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		jobs, dataB := tx.Bucket(jobsBucket), tx.Bucket(dataBucket)
+		id, err := jobs.NextSequence()
+		if err != nil {
+			return err
+		}
+		rec.ID = id
+		if err := putJSON(jobs, key(id), rec); err != nil {
+			return err
+		}
+		if err := dataB.Put(key(id), data); err != nil {
+			return err
+		}
+		// IDs are sequential, so the jobs over the size are the ones up to
+		// id-size. Jobs that didn't finish are kept, held ones have to print
+		// after the restart.
+		var old [][]byte
+		c := jobs.Cursor()
+		for k, v := c.First(); k != nil && int64(binary.BigEndian.Uint64(k)) <= int64(id)-int64(s.size); k, v = c.Next() {
+			var rec JobRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			switch rec.Status {
+			case statusQueued, statusPrinting, statusHeld:
+			default:
+				old = append(old, k)
+			}
+		}
+		for _, k := range old {
+			if err := jobs.Delete(k); err != nil {
+				return err
+			}
+			if err := dataB.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Replaces a record.
+func (s *JobStore) Update(rec *JobRecord) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		jobs := tx.Bucket(jobsBucket)
+		if jobs.Get(key(rec.ID)) == nil {
+			return errNoJob
+		}
+		return putJSON(jobs, key(rec.ID), rec)
+	})
+}
+
+// Returns a record and the data of its job.
+func (s *JobStore) Get(id uint64) (*JobRecord, []byte, error) {
+	var rec JobRecord
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(jobsBucket).Get(key(id))
+		if v == nil {
+			return errNoJob
+		}
+		data = append([]byte(nil), tx.Bucket(dataBucket).Get(key(id))...)
+		return json.Unmarshal(v, &rec)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &rec, data, nil
+}
+
+// Returns the records of the held jobs, oldest first.
+func (s *JobStore) Held() ([]*JobRecord, error) {
+	var recs []*JobRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+			var rec JobRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			if rec.Status == statusHeld {
+				recs = append(recs, &rec)
+			}
+			return nil
+		})
+	})
+	return recs, err
+}
+
+// Returns up to limit records, newest first, that match.
+func (s *JobStore) List(limit int, match func(*JobRecord) bool) ([]*JobRecord, error) {
+	recs := []*JobRecord{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(jobsBucket).Cursor()
+		for k, v := c.Last(); k != nil && len(recs) < limit; k, v = c.Prev() {
+			var rec JobRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			if match(&rec) {
+				recs = append(recs, &rec)
+			}
+		}
+		return nil
+	})
+	return recs, err
+}
+
+// Returns the response to the request with the idempotency key, if the key
+// was used after since.
+func (s *JobStore) GetKey(key string, since time.Time) (*printResult, error) {
+	var rec keyRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(keysBucket).Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &rec)
+	})
+	if err != nil || rec.Result == nil || rec.Time.Before(since) {
+		return nil, err
+	}
+	return rec.Result, nil
+}
+
+// Saves the response to the request with an idempotency key, and removes the
+// keys older than window.
+func (s *JobStore) PutKey(key string, res *printResult, now time.Time, window time.Duration) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		keys := tx.Bucket(keysBucket)
+		c := keys.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var rec keyRecord
+			if json.Unmarshal(v, &rec) != nil || now.Sub(rec.Time) > window {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return putJSON(keys, []byte(key), &keyRecord{Result: res, Time: now})
+	})
+}
+
+// Returns the paper usage of a printer, zero if there is none yet.
+func (s *JobStore) GetUsage(printer string) (Usage, error) {
+	var u Usage
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(usageBucket).Get([]byte(printer))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &u)
+	})
+	return u, err
+}
+
+func (s *JobStore) PutUsage(printer string, u *Usage) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(usageBucket), []byte(printer), u)
+	})
+}
+
+// Keys are big endian IDs, so they sort by age.
+func key(id uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, id)
+}
+
+func putJSON(b *bolt.Bucket, k []byte, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(k, data)
+}
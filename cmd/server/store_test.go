@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestAddPrunesFinishedJobs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	s, err := openJobStore(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { s.Close() }()
+	statuses := []string{
+		statusQueued, statusDone, statusHeld, statusFailed,
+		statusPrinting, statusCancelled, statusDone, statusDone,
+	}
+	for _, status := range statuses {
+		if err := s.Add(&JobRecord{Status: status}, []byte(status)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The finished jobs but the newest three are gone, with their data
+	for id, status := range statuses {
+		rec, data, err := s.Get(uint64(id + 1))
+		kept := id+1 > len(statuses)-3 || status == statusQueued || status == statusPrinting || status == statusHeld
+		switch {
+		case !kept && !errors.Is(err, errNoJob):
+			t.Errorf("job %d, %s: got %v, want it removed", id+1, status, err)
+		case kept && err != nil:
+			t.Errorf("job %d, %s: %v", id+1, status, err)
+		case kept && (rec.Status != status || string(data) != status):
+			t.Errorf("job %d: got %s with data %q, want %s", id+1, rec.Status, data, status)
+		}
+	}
+	recs, err := s.List(10, func(*JobRecord) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []uint64
+	for _, rec := range recs {
+		ids = append(ids, rec.ID)
+	}
+	if want := []uint64{8, 7, 6, 5, 3, 1}; !slices.Equal(ids, want) {
+		t.Errorf("listed jobs %v, want %v", ids, want)
+	}
+
+	// Jobs that were queued or printing failed when the server stopped, held
+	// ones are kept to print
+	s.Close()
+	if s, err = openJobStore(path, 3); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[uint64]string{1: statusFailed, 3: statusHeld, 5: statusFailed, 6: statusCancelled} {
+		if rec, _, err := s.Get(id); err != nil || rec.Status != want {
+			t.Errorf("job %d after a restart: got %v %v, want %s", id, rec, err, want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+const tokenUsage = `Usage:
+  escpos-server token add [-file tokens.yaml] [-scopes print] [-rate N] [-daily-paper MM] [-validation strip] NAME
+  escpos-server token list [-file tokens.yaml]
+  escpos-server token remove [-file tokens.yaml] NAME
+
+Scopes: print, preview, drawer and admin, comma separated. Validation rejects
+or strips commands that change saved settings or NV memory, turn the printer
+off or open the drawer without the drawer scope.
+`
+
+// Runs the token subcommand, which manages the tokens file.
+func tokenCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(tokenUsage)
+	}
+	fset := flag.NewFlagSet("token "+args[0], flag.ExitOnError)
+	file := fset.String("file", "tokens.yaml", "Tokens file")
+	scopeList := fset.String("scopes", scopePrint, "Scopes of a new token")
+	rate := fset.Int("rate", 0, "Requests per minute of a new token, 0 for no limit")
+	dailyPaper := fset.Int64("daily-paper", 0, "Millimetres of paper a new token can print per day, 0 for no limit")
+	validation := fset.String("validation", "", "Validation of raw jobs of a new token: off, reject or strip")
+	fset.Parse(args[1:])
+
+	f, err := loadTokens(*file)
+	if errors.Is(err, fs.ErrNotExist) && args[0] == "add" {
+		f, err = &TokenFile{}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "add":
+		if fset.NArg() != 1 {
+			return errors.New(tokenUsage)
+		}
+		token, err := newToken()
+		if err != nil {
+			return err
+		}
+		t := &Token{
+			Name:       fset.Arg(0),
+			Hash:       hashToken(token),
+			Scopes:     strings.Split(*scopeList, ","),
+			Rate:       *rate,
+			DailyPaper: *dailyPaper,
+			Validation: *validation,
+		}
+		f.Tokens = append(f.Tokens, t)
+		if err := f.validate(); err != nil {
+			return err
+		}
+		if err := f.save(*file); err != nil {
+			return err
+		}
+		fmt.Printf("Added token %s, it is only shown this once:\n%s\n", t.Name, token)
+
+	case "list":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tSCOPES\tRATE\tDAILY PAPER\tVALIDATION")
+		for _, t := range f.Tokens {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", t.Name, strings.Join(t.Scopes, ","), limit(int64(t.Rate)), limit(t.DailyPaper), cmp.Or(t.Validation, "-"))
+		}
+		tw.Flush()
+
+	case "remove":
+		if fset.NArg() != 1 {
+			return errors.New(tokenUsage)
+		}
+		i := slices.IndexFunc(f.Tokens, func(t *Token) bool { return t.Name == fset.Arg(0) })
+		if i < 0 {
+			return fmt.Errorf("no token %s", fset.Arg(0))
+		}
+		f.Tokens = slices.Delete(f.Tokens, i, i+1)
+		if err := f.save(*file); err != nil {
+			return err
+		}
+		fmt.Println("Removed token", fset.Arg(0))
+
+	default:
+		return errors.New(tokenUsage)
+	}
+	return nil
+}
+
+func limit(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return fmt.Sprint(n)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/petertjmills/escpos-server/escpos"
+)
+
+// Usage is the paper and cutter use of a printer, estimated from its jobs.
+type Usage struct {
+	Paper        float64   `json:"paper_mm"` // since the roll was replaced
+	TotalPaper   float64   `json:"total_paper_mm"`
+	Cuts         int       `json:"cuts"` // of the cutter, never reset
+	RollReplaced time.Time `json:"roll_replaced,omitzero"`
+}
+
+// Adds the paper of a job and saves the usage. Needs p.mu.
+func (p *Printer) addUsage(paper escpos.Paper) {
+	if paper.Dots == 0 && paper.Cuts == 0 {
+		return
+	}
+	p.usage.Paper += paper.Millimetres()
+	p.usage.TotalPaper += paper.Millimetres()
+	p.usage.Cuts += paper.Cuts
+	p.saveUsage()
+}
+
+// Saves the usage in the job history. Needs p.mu, so saves are in order.
+func (p *Printer) saveUsage() {
+	if err := p.store.PutUsage(p.Name, &p.usage); err != nil {
+		log.Printf("Failed to save paper usage of printer %s: %v", p.Name, err)
+	}
+}
+
+// Returns the paper left on the roll in millimetres, if the roll length is
+// configured. Needs p.mu.
+func (p *Printer) rollLeft() (float64, bool) {
+	if p.roll == 0 {
+		return 0, false
+	}
+	return max(p.roll-p.usage.Paper, 0), true
+}
+
+// Starts counting the paper of a new roll.
+func (p *Printer) ReplaceRoll(now time.Time) {
+	p.update(func() {
+		p.usage.Paper, p.usage.RollReplaced = 0, now
+		p.saveUsage()
+	})
+}
+
+// Resets the paper used of a printer after its roll was replaced.
+func (s *Server) handleRollReplaced(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.printers[r.PathValue("name")]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown printer %q", r.PathValue("name")), http.StatusNotFound)
+		return
+	}
+	p.ReplaceRoll(time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.Status())
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/petertjmills/escpos-server/escpos"
+)
+
+// Validation modes for raw ESC/POS jobs.
+const (
+	validateOff    = "off"
+	validateReject = "reject"
+	validateStrip  = "strip"
+)
+
+// Returns why a command shouldn't be sent by a client that isn't trusted, or
+// "" if it is fine. Drawer pulses are fine for tokens with the drawer scope.
+func dangerous(c escpos.Command, t *Token) string {
+	arg := func(i int) byte {
+		if i < len(c.Data) {
+			return c.Data[i]
+		}
+		return 0
+	}
+	switch c.Name {
+	case "GS ( E":
+		return "user setup commands change the saved settings"
+	case "GS ( M":
+		return "customize commands can save settings"
+	case "GS ( C", "FS g 1":
+		return "writes to the NV user memory"
+	case "FS q":
+		return "defines NV bit images"
+	case "GS ( L", "GS 8 L":
+		// m fn after the length, fn 65 to 68 delete and define NV graphics
+		fn := arg(6)
+		if c.Name == "GS 8 L" {
+			fn = arg(8)
+		}
+		if fn >= 65 && fn <= 68 {
+			return "deletes or defines NV graphics"
+		}
+	case "DLE DC4":
+		switch arg(2) {
+		case 2:
+			return "turns the printer off"
+		case 8:
+			return "clears the buffers"
+		}
+	}
+	if opensDrawer(c) && (t == nil || !t.Allows(scopeDrawer)) {
+		return "opens the cash drawer"
+	}
+	return ""
+}
+
+// Reports whether a command pulses a drawer pin, ESC p or DLE DC4 1.
+func opensDrawer(c escpos.Command) bool {
+	return c.Name == "ESC p" || c.Name == "DLE DC4" && len(c.Data) > 2 && c.Data[2] == 1
+}
+
+// Reports whether a raw job opens the cash drawer. A job the parser doesn't
+// understand may, so it does.
+func hasDrawer(data []byte) bool {
+	cmds, err := escpos.ParseCommands(data)
+	return err != nil || slices.ContainsFunc(cmds, opensDrawer)
+}
+
+// Checks a raw ESC/POS job. Reject fails if it has a dangerous command, strip
+// removes them. Both need a job made of commands the parser knows.
+func validate(data []byte, mode string, t *Token) ([]byte, error) {
+	if mode == validateOff || mode == "" {
+		return data, nil
+	}
+	cmds, err := escpos.ParseCommands(data)
+	if err != nil {
+		return nil, err
+	}
+	var out []byte
+	offset := 0
+	for _, c := range cmds {
+		if reason := dangerous(c, t); reason != "" {
+			if mode == validateReject {
+				return nil, fmt.Errorf("offset %d: %s %s", offset, c.Name, reason)
+			}
+		} else {
+			out = append(out, c.Data...)
+		}
+		offset += len(c.Data)
+	}
+	return out, nil
+}
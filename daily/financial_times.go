@@ -2,7 +2,6 @@ package daily
 
 import (
 	"bytes"
-	"html/template"
 	"io"
 	"net/http"
 	"strings"
@@ -70,17 +69,6 @@ func GetNews() (string, error) {
 
 	})
 
-	tmpl, err := template.New("news").Parse(NEWSTEMPLATE)
-	if err != nil {
-		return "", err
-	}
-
-	var ret strings.Builder
-
-	if err := tmpl.Execute(&ret, w); err != nil {
-		return "", err
-	}
-
-	return ret.String(), nil
+	return Templates.Render("news", w)
 
 }
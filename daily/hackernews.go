@@ -5,7 +5,6 @@ import (
 	"io"
 	"net/http"
 	"strings"
-	"text/template"
 
 	"github.com/PuerkitoBio/goquery"
 )
@@ -13,9 +12,8 @@ import (
 var HNFRONTTEMPLATE = `
 #### Hacker News
 {{ range .Stories }}
-- {{ .Title }}
+- [{{ .Title }}](https://news.ycombinator.com/item?id={{ .ID }})
   - Comments: {{ .Comments }}
-  - ID: {{ .ID }}
 {{ end }}
 `
 
@@ -83,13 +81,5 @@ func GetHackerNewsFront() (string, error) {
 	})
 
 	data := HNFrontStories{Stories: stories}
-	tmpl, err := template.New("hnfront").Parse(HNFRONTTEMPLATE)
-	if err != nil {
-		return "", err
-	}
-	var ret strings.Builder
-	if err := tmpl.Execute(&ret, data); err != nil {
-		return "", err
-	}
-	return ret.String(), nil
+	return Templates.Render("hnfront", data)
 }
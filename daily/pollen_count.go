@@ -2,7 +2,6 @@ package daily
 
 import (
 	"bytes"
-	"html/template"
 	"io"
 	"net/http"
 	"strings"
@@ -87,15 +86,5 @@ func GetPollenCount() (string, error) {
 		DayAfter:     dayAfter,
 	}
 
-	tmpl, err := template.New("pollen").Parse(POLLENCOUNTTEMPLATE)
-	if err != nil {
-		return "", err
-	}
-
-	var ret strings.Builder
-	if err := tmpl.Execute(&ret, data); err != nil {
-		return "", err
-	}
-
-	return ret.String(), nil
+	return Templates.Render("pollen", data)
 }
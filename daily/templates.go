@@ -0,0 +1,31 @@
+package daily
+
+import (
+	"os"
+
+	"github.com/petertjmills/escpos-server/templates"
+)
+
+// Templates the daily sections are rendered with, named weather, wotd, news,
+// pollen and hnfront. LoadTemplates replaces them with files.
+var Templates = templates.New()
+
+func init() {
+	for name, text := range map[string]string{
+		"weather": WEATHERTEMPLATE,
+		"wotd":    WOTDTEMPLATE,
+		"news":    NEWSTEMPLATE,
+		"pollen":  POLLENCOUNTTEMPLATE,
+		"hnfront": HNFRONTTEMPLATE,
+	} {
+		if err := Templates.Parse(name, text); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Replaces the default templates with the *.tmpl files in dir, e.g.
+// weather.md.tmpl for the weather section.
+func LoadTemplates(dir string) error {
+	return Templates.ParseFS(os.DirFS(dir), "*.tmpl")
+}
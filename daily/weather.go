@@ -3,7 +3,6 @@ package daily
 import (
 	"encoding/json"
 	"fmt"
-	"html/template"
 	"math"
 	"net/http"
 	"os"
@@ -260,16 +259,9 @@ func GetWeatherMD() string {
 		res.PrintRain = true
 	}
 
-	tmpl, err := template.New("weather").Parse(WEATHERTEMPLATE)
+	ret, err := Templates.Render("weather", res)
 	if err != nil {
-		return fmt.Sprintf("Error parsing weather template: %v", err)
-	}
-
-	var ret strings.Builder
-
-	if err := tmpl.Execute(&ret, res); err != nil {
 		return fmt.Sprintf("Error executing weather template: %v", err)
 	}
-
-	return ret.String()
+	return ret
 }
@@ -2,7 +2,6 @@ package daily
 
 import (
 	"bytes"
-	"html/template"
 	"io"
 	"net/http"
 	"strings"
@@ -59,17 +58,6 @@ func GetWordOfTheDay() (string, error) {
 		Def:  def,
 	}
 
-	tmpl, err := template.New("weather").Parse(WOTDTEMPLATE)
-	if err != nil {
-		return "", err
-	}
-
-	var ret strings.Builder
-
-	if err := tmpl.Execute(&ret, w); err != nil {
-		return "", err
-	}
-
-	return ret.String(), nil
+	return Templates.Render("wotd", w)
 
 }
@@ -0,0 +1,184 @@
+// Package document is a declarative receipt format. A Document is a list of
+// blocks, like styled text, images, barcodes and tables, that can be stored
+// and sent as JSON or YAML and rendered onto an ESC/POS printer.
+//
+//	{"blocks": [
+//		{"text": {"content": "Corner Cafe", "style": {"align": "center", "width": 2, "height": 2}}},
+//		{"separator": {}},
+//		{"table": {"columns": [{"header": "Item"}, {"header": "Price", "align": "right"}],
+//			"rows": [["Coffee", "$3.50"]]}},
+//		{"qr": {"data": "https://example.com"}},
+//		{"cut": {}}
+//	]}
+package document
+
+//go:generate go run ../cmd/docschema -o schema.json
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Document is a receipt as data.
+type Document struct {
+	Blocks []Block `json:"blocks" yaml:"blocks" doc:"Blocks printed from top to bottom."`
+}
+
+// Block is one element of a document. Exactly one of its fields is set.
+type Block struct {
+	Text      *Text      `json:"text,omitempty" yaml:"text,omitempty" doc:"Text wrapped to the paper width."`
+	Image     *Image     `json:"image,omitempty" yaml:"image,omitempty" doc:"An image scaled down to the paper width."`
+	Barcode   *Barcode   `json:"barcode,omitempty" yaml:"barcode,omitempty" doc:"A barcode."`
+	QR        *QR        `json:"qr,omitempty" yaml:"qr,omitempty" doc:"A QR code."`
+	Table     *Table     `json:"table,omitempty" yaml:"table,omitempty" doc:"A table sized to its content."`
+	Separator *Separator `json:"separator,omitempty" yaml:"separator,omitempty" doc:"A line across the paper."`
+	Feed      *Feed      `json:"feed,omitempty" yaml:"feed,omitempty" doc:"Blank lines."`
+	Cut       *Cut       `json:"cut,omitempty" yaml:"cut,omitempty" doc:"Cuts the paper."`
+}
+
+// Style is the style of text.
+type Style struct {
+	Bold      bool   `json:"bold,omitempty" yaml:"bold,omitempty"`
+	Underline uint8  `json:"underline,omitempty" yaml:"underline,omitempty" doc:"Underline thickness in dots." max:"2"`
+	Reverse   bool   `json:"reverse,omitempty" yaml:"reverse,omitempty" doc:"White text on black."`
+	Align     string `json:"align,omitempty" yaml:"align,omitempty" enum:"left,center,right"`
+	Width     uint8  `json:"width,omitempty" yaml:"width,omitempty" doc:"Character width multiplier." min:"1" max:"8"`
+	Height    uint8  `json:"height,omitempty" yaml:"height,omitempty" doc:"Character height multiplier." min:"1" max:"8"`
+	Font      string `json:"font,omitempty" yaml:"font,omitempty" enum:"a,b"`
+}
+
+// Text is text wrapped to the paper width. Newlines start a new line, other
+// control characters are left out.
+type Text struct {
+	Content string `json:"content" yaml:"content"`
+	Style   *Style `json:"style,omitempty" yaml:"style,omitempty"`
+}
+
+// Image is an image loaded from a file or embedded as base64.
+type Image struct {
+	Path  string `json:"path,omitempty" yaml:"path,omitempty" doc:"Path of a PNG, JPEG or GIF file, relative to the image directory of the renderer."`
+	Data  string `json:"data,omitempty" yaml:"data,omitempty" doc:"Base64 encoded PNG, JPEG or GIF image."`
+	Align string `json:"align,omitempty" yaml:"align,omitempty" enum:"left,center,right"`
+}
+
+// Barcode is a one-dimensional barcode.
+type Barcode struct {
+	Data   string `json:"data" yaml:"data"`
+	Type   string `json:"type,omitempty" yaml:"type,omitempty" doc:"Symbology, code128 if not set." enum:"code128,code39,ean13,ean8,upca,upce"`
+	Height uint8  `json:"height,omitempty" yaml:"height,omitempty" doc:"Height in dots." min:"1"`
+	Width  uint8  `json:"width,omitempty" yaml:"width,omitempty" doc:"Module width in dots." min:"2" max:"6"`
+	HRI    string `json:"hri,omitempty" yaml:"hri,omitempty" doc:"Position of the human readable text, below if not set." enum:"none,above,below,both"`
+	Align  string `json:"align,omitempty" yaml:"align,omitempty" enum:"left,center,right"`
+}
+
+// QR is a QR code.
+type QR struct {
+	Data  string `json:"data" yaml:"data"`
+	Size  uint8  `json:"size,omitempty" yaml:"size,omitempty" doc:"Module size in dots, 6 if not set." min:"1" max:"16"`
+	Level string `json:"level,omitempty" yaml:"level,omitempty" doc:"Error correction level, M if not set." enum:"L,M,Q,H"`
+	Align string `json:"align,omitempty" yaml:"align,omitempty" enum:"left,center,right"`
+}
+
+// Table is a table of text cells.
+type Table struct {
+	Columns  []Column   `json:"columns,omitempty" yaml:"columns,omitempty" doc:"Headers and alignment of the columns."`
+	Rows     [][]string `json:"rows" yaml:"rows"`
+	Border   string     `json:"border,omitempty" yaml:"border,omitempty" doc:"Border style, none if not set." enum:"none,ascii,box"`
+	Truncate bool       `json:"truncate,omitempty" yaml:"truncate,omitempty" doc:"Cut cells that don't fit instead of wrapping them."`
+	Style    *Style     `json:"style,omitempty" yaml:"style,omitempty"`
+}
+
+// Column is a column of a table.
+type Column struct {
+	Header string `json:"header,omitempty" yaml:"header,omitempty"`
+	Align  string `json:"align,omitempty" yaml:"align,omitempty" enum:"left,center,right"`
+}
+
+// Separator is a line of a repeated character across the paper.
+type Separator struct {
+	Char string `json:"char,omitempty" yaml:"char,omitempty" doc:"Character the line is made of, - if not set."`
+}
+
+// Feed is a number of blank lines.
+type Feed struct {
+	Lines uint8 `json:"lines,omitempty" yaml:"lines,omitempty" doc:"Number of lines, 1 if not set."`
+}
+
+// Cut cuts the paper.
+type Cut struct{}
+
+// Decodes a document from JSON. Unknown fields are an error.
+func DecodeJSON(data []byte) (*Document, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var d Document
+	if err := dec.Decode(&d); err != nil {
+		return nil, fmt.Errorf("failed to decode document: %w", err)
+	}
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Decodes a document from YAML. Unknown fields are an error.
+func DecodeYAML(data []byte) (*Document, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var d Document
+	if err := dec.Decode(&d); err != nil {
+		return nil, fmt.Errorf("failed to decode document: %w", err)
+	}
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Encodes the document as indented JSON.
+func (d *Document) EncodeJSON() ([]byte, error) {
+	return json.MarshalIndent(d, "", "  ")
+}
+
+// Encodes the document as YAML.
+func (d *Document) EncodeYAML() ([]byte, error) {
+	return yaml.Marshal(d)
+}
+
+// Checks that every block has exactly one field set and that values are in
+// range. Decoding validates the document already.
+func (d *Document) Validate() error {
+	for i, b := range d.Blocks {
+		if err := b.validate(); err != nil {
+			return fmt.Errorf("block %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (b Block) validate() error {
+	set := 0
+	v := reflect.ValueOf(b)
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Field(i).IsNil() {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("a block needs exactly one of text, image, barcode, qr, table, separator, feed and cut, got %d", set)
+	}
+	switch {
+	case b.Image != nil && (b.Image.Path == "") == (b.Image.Data == ""):
+		return fmt.Errorf("an image needs either a path or data")
+	case b.Barcode != nil && b.Barcode.Data == "":
+		return fmt.Errorf("a barcode needs data")
+	case b.QR != nil && b.QR.Data == "":
+		return fmt.Errorf("a qr code needs data")
+	}
+	// Ranges and enums come from the struct tags the schema is generated from
+	return checkTags(v)
+}
@@ -0,0 +1,137 @@
+package document
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/petertjmills/escpos-server/escpos"
+)
+
+func TestDecode(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		json string
+		yaml string
+		want string // error, empty if it decodes
+	}{
+		{
+			name: "valid",
+			json: `{"blocks": [{"text": {"content": "Hi", "style": {"bold": true, "align": "center"}}}, {"cut": {}}]}`,
+			yaml: "blocks:\n  - text: {content: Hi, style: {bold: true, align: center}}\n  - cut: {}\n",
+		},
+		{
+			name: "unknown block",
+			json: `{"blocks": [{"logo": {}}]}`,
+			yaml: "blocks:\n  - logo: {}\n",
+			want: "logo",
+		},
+		{
+			name: "unknown field",
+			json: `{"blocks": [{"text": {"content": "Hi", "colour": "red"}}]}`,
+			yaml: "blocks:\n  - text: {content: Hi, colour: red}\n",
+			want: "colour",
+		},
+		{
+			name: "two fields in a block",
+			json: `{"blocks": [{"text": {"content": "Hi"}, "cut": {}}]}`,
+			yaml: "blocks:\n  - text: {content: Hi}\n    cut: {}\n",
+			want: "block 0: a block needs exactly one",
+		},
+		{
+			name: "out of range",
+			json: `{"blocks": [{"cut": {}}, {"barcode": {"data": "123", "width": 7}}]}`,
+			yaml: "blocks:\n  - cut: {}\n  - barcode: {data: \"123\", width: 7}\n",
+			want: "block 1:",
+		},
+	} {
+		for _, format := range []struct {
+			name   string
+			decode func([]byte) (*Document, error)
+			data   string
+		}{{"json", DecodeJSON, tt.json}, {"yaml", DecodeYAML, tt.yaml}} {
+			d, err := format.decode([]byte(format.data))
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("%s in %s: %v", tt.name, format.name, err)
+			case tt.want == "" && len(d.Blocks) != 2:
+				t.Errorf("%s in %s: got %d blocks, want 2", tt.name, format.name, len(d.Blocks))
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("%s in %s: got %v, want an error with %q", tt.name, format.name, err, tt.want)
+			}
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		block Block
+		want  string // error, empty if it is valid
+	}{
+		{"text", Block{Text: &Text{Content: "Hi"}}, ""},
+		{"empty", Block{}, "exactly one"},
+		{"image without source", Block{Image: &Image{}}, "either a path or data"},
+		{"image with both sources", Block{Image: &Image{Path: "logo.png", Data: "AA=="}}, "either a path or data"},
+		{"barcode without data", Block{Barcode: &Barcode{}}, "a barcode needs data"},
+		{"qr without data", Block{QR: &QR{}}, "a qr code needs data"},
+		{"barcode width", Block{Barcode: &Barcode{Data: "1", Width: 1}}, "width"},
+		{"qr size", Block{QR: &QR{Data: "x", Size: 17}}, "size"},
+		{"text width", Block{Text: &Text{Content: "Hi", Style: &Style{Width: 9}}}, "width"},
+		{"align", Block{Text: &Text{Content: "Hi", Style: &Style{Align: "middle"}}}, "align"},
+		{"border", Block{Table: &Table{Border: "double"}}, "border"},
+	} {
+		err := (&Document{Blocks: []Block{tt.block}}).Validate()
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: got %v, want an error with %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestPlain(t *testing.T) {
+	for _, tt := range []struct {
+		text, want string
+	}{
+		{"Hello\nworld", "Hello\nworld"},
+		{"\x1bp\x00\x19\u00faopen", "p\u00faopen"},
+		{"tab\tbell\a\x7fdel", "tabbelldel"},
+		{"Café ☕", "Café ☕"},
+	} {
+		if got := plain(tt.text); got != tt.want {
+			t.Errorf("plain(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// Text with ESC bytes from either format prints without them.
+func TestRenderStripsControlCharacters(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		decode func([]byte) (*Document, error)
+		data   string
+	}{
+		{"json", DecodeJSON, `{"blocks": [{"text": {"content": "open\u001bp\u0000\u0019\u00fa"}}, {"separator": {"char": "\u001b"}}]}`},
+		{"yaml", DecodeYAML, "blocks:\n  - text: {content: \"open\\ep\\0\\x19\\xfa\"}\n  - separator: {char: \"\\e\"}\n"},
+	} {
+		d, err := tt.decode([]byte(tt.data))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var out bytes.Buffer
+		p := escpos.New(&out)
+		if err := d.Render(p); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		p.Print()
+		if bytes.Contains(out.Bytes(), []byte("\x1bp")) || !bytes.Contains(out.Bytes(), []byte("openpú\n")) {
+			t.Errorf("%s: printed %q", tt.name, out.Bytes())
+		}
+		// The separator of only ESC falls back to -
+		if !bytes.Contains(out.Bytes(), []byte(strings.Repeat("-", 48)+"\n")) {
+			t.Errorf("%s: printed %q, want a line of -", tt.name, out.Bytes())
+		}
+	}
+}
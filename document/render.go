@@ -0,0 +1,261 @@
+package document
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/petertjmills/escpos-server/escpos"
+)
+
+type renderOptions struct {
+	imageDir     string
+	noImageFiles bool
+}
+
+// RenderOption configures how a document is rendered.
+type RenderOption func(*renderOptions)
+
+// Sets the directory relative image paths are loaded from. By default they
+// are relative to the working directory.
+func WithImageDir(dir string) RenderOption {
+	return func(o *renderOptions) {
+		o.imageDir = dir
+	}
+}
+
+// Prints only images embedded as data, for documents from untrusted sources.
+func WithoutImageFiles() RenderOption {
+	return func(o *renderOptions) {
+		o.noImageFiles = true
+	}
+}
+
+// Render writes the document to p. The style of p is restored afterwards.
+func (d *Document) Render(p *escpos.Escpos, opts ...RenderOption) error {
+	var o renderOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	saved := p.Style
+	defer func() { p.Style = saved }()
+
+	for i, b := range d.Blocks {
+		p.Style = saved
+		if err := b.render(p, o); err != nil {
+			return fmt.Errorf("block %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (b Block) render(p *escpos.Escpos, o renderOptions) error {
+	var err error
+	switch {
+	case b.Text != nil:
+		p.Style = b.Text.Style.apply(p.Style)
+		_, err = p.WriteWrapped(plain(b.Text.Content))
+	case b.Image != nil:
+		err = b.Image.render(p, o)
+	case b.Barcode != nil:
+		err = b.Barcode.render(p)
+	case b.QR != nil:
+		err = b.QR.render(p)
+	case b.Table != nil:
+		err = b.Table.render(p)
+	case b.Separator != nil:
+		char := plain(b.Separator.Char)
+		if char == "" {
+			char = "-"
+		}
+		p.Justify(escpos.JustifyLeft)
+		if n := escpos.StringWidth(char); n > 0 {
+			_, err = p.Write(strings.Repeat(char, p.Columns()/n) + "\n")
+		}
+	case b.Feed != nil:
+		lines := b.Feed.Lines
+		if lines == 0 {
+			lines = 1
+		}
+		_, err = p.LineFeedD(lines)
+	case b.Cut != nil:
+		_, err = p.Cut()
+	}
+	return err
+}
+
+// Removes the control characters but newlines from text, so the text of a
+// document can't hold printer commands like ESC p.
+func plain(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' && r != '\n' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// Returns base with the style applied. A nil style leaves base unchanged.
+func (s *Style) apply(base escpos.Style) escpos.Style {
+	if s == nil {
+		return base
+	}
+	base.Bold = s.Bold
+	base.Underline = s.Underline
+	base.Reverse = s.Reverse
+	base.Justify = justify(s.Align, base.Justify)
+	if s.Width > 0 {
+		base.Width = s.Width
+	}
+	if s.Height > 0 {
+		base.Height = s.Height
+	}
+	switch s.Font {
+	case "a":
+		base.Font = escpos.FontA
+	case "b":
+		base.Font = escpos.FontB
+	}
+	return base
+}
+
+func justify(align string, def uint8) uint8 {
+	switch align {
+	case "left":
+		return escpos.JustifyLeft
+	case "center":
+		return escpos.JustifyCenter
+	case "right":
+		return escpos.JustifyRight
+	}
+	return def
+}
+
+// Applies the justification before a barcode, QR code or image.
+func applyAlign(p *escpos.Escpos, align string) error {
+	p.Justify(justify(align, p.Style.Justify))
+	// Writing nothing applies the justification
+	_, err := p.Write("")
+	return err
+}
+
+func (i *Image) render(p *escpos.Escpos, o renderOptions) error {
+	var data []byte
+	var err error
+	switch {
+	case i.Data != "":
+		data, err = base64.StdEncoding.DecodeString(i.Data)
+	case o.noImageFiles:
+		return fmt.Errorf("images from files are not allowed")
+	default:
+		path := i.Path
+		if !filepath.IsAbs(path) && o.imageDir != "" {
+			path = filepath.Join(o.imageDir, path)
+		}
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load image: %w", err)
+	}
+	img, err := escpos.DecodeImage(data)
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+	if err := applyAlign(p, i.Align); err != nil {
+		return err
+	}
+	_, err = p.PrintImageFit(img)
+	return err
+}
+
+func (b *Barcode) render(p *escpos.Escpos) error {
+	if err := applyAlign(p, b.Align); err != nil {
+		return err
+	}
+	if b.Height > 0 {
+		if _, err := p.BarcodeHeight(b.Height); err != nil {
+			return err
+		}
+	}
+	if b.Width > 0 {
+		if _, err := p.BarcodeWidth(b.Width); err != nil {
+			return err
+		}
+	}
+	hri := map[string]uint8{"none": 0, "above": 1, "": 2, "below": 2, "both": 3}[b.HRI]
+	if _, err := p.HRIPosition(hri); err != nil {
+		return err
+	}
+
+	var err error
+	switch b.Type {
+	case "", "code128":
+		_, err = p.Code128(b.Data)
+	case "code39":
+		_, err = p.Code39(b.Data)
+	case "ean13":
+		_, err = p.EAN13(b.Data)
+	case "ean8":
+		_, err = p.EAN8(b.Data)
+	case "upca":
+		_, err = p.UPCA(b.Data)
+	case "upce":
+		_, err = p.UPCE(b.Data)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = p.Write("\n")
+	return err
+}
+
+func (q *QR) render(p *escpos.Escpos) error {
+	if err := applyAlign(p, q.Align); err != nil {
+		return err
+	}
+	size := q.Size
+	if size == 0 {
+		size = 6
+	}
+	level := map[string]uint8{
+		"L": escpos.QRCodeErrorCorrectionLevelL,
+		"":  escpos.QRCodeErrorCorrectionLevelM,
+		"M": escpos.QRCodeErrorCorrectionLevelM,
+		"Q": escpos.QRCodeErrorCorrectionLevelQ,
+		"H": escpos.QRCodeErrorCorrectionLevelH,
+	}[q.Level]
+	_, err := p.QRCode(q.Data, true, size, level)
+	return err
+}
+
+func (t *Table) render(p *escpos.Escpos) error {
+	p.Style = t.Style.apply(p.Style)
+	cell := func(text string) escpos.TableCell {
+		return escpos.TableCell{{Text: plain(text), Style: p.Style}}
+	}
+	table := escpos.Table{
+		Border:   map[string]escpos.TableBorder{"ascii": escpos.BorderASCII, "box": escpos.BorderBox}[t.Border],
+		Truncate: t.Truncate,
+	}
+	hasHeader := false
+	for _, c := range t.Columns {
+		table.Align = append(table.Align, justify(c.Align, escpos.JustifyLeft))
+		hasHeader = hasHeader || c.Header != ""
+	}
+	if hasHeader {
+		for _, c := range t.Columns {
+			table.Header = append(table.Header, cell(c.Header))
+		}
+	}
+	for _, row := range t.Rows {
+		var cells []escpos.TableCell
+		for _, text := range row {
+			cells = append(cells, cell(text))
+		}
+		table.Rows = append(table.Rows, cells)
+	}
+	_, err := p.WriteTable(table)
+	return err
+}
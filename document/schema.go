@@ -0,0 +1,160 @@
+package document
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Schema returns the JSON Schema of Document, generated from the Go types.
+// Fields are described by their doc tag, and enum, min and max tags restrict
+// their values. schema.json is generated from it with go generate.
+func Schema() ([]byte, error) {
+	g := &schemaGenerator{defs: map[string]any{}}
+	root := g.schema(reflect.TypeOf(Document{}))
+	s := map[string]any{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   "Receipt document",
+		"$ref":    root["$ref"],
+		"$defs":   g.defs,
+	}
+	return json.MarshalIndent(s, "", "  ")
+}
+
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Uint8:
+		return map[string]any{"type": "integer", "minimum": 0, "maximum": 255}
+	case reflect.Int, reflect.Int64, reflect.Uint16:
+		return map[string]any{"type": "integer"}
+	case reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Struct:
+		ref := map[string]any{"$ref": "#/$defs/" + t.Name()}
+		if _, ok := g.defs[t.Name()]; ok {
+			return ref
+		}
+		def := map[string]any{"type": "object", "additionalProperties": false}
+		g.defs[t.Name()] = def // before the fields, for recursive types
+		properties := map[string]any{}
+		required := []string{}
+		for _, f := range reflect.VisibleFields(t) {
+			name, omitempty := jsonName(f)
+			if name == "" {
+				continue
+			}
+			s := g.schema(f.Type)
+			if doc := f.Tag.Get("doc"); doc != "" {
+				s["description"] = doc
+			}
+			if enum := f.Tag.Get("enum"); enum != "" {
+				s["enum"] = strings.Split(enum, ",")
+			}
+			if v, err := strconv.Atoi(f.Tag.Get("min")); err == nil {
+				s["minimum"] = v
+			}
+			if v, err := strconv.Atoi(f.Tag.Get("max")); err == nil {
+				s["maximum"] = v
+			}
+			properties[name] = s
+			if !omitempty {
+				required = append(required, name)
+			}
+		}
+		def["properties"] = properties
+		if len(required) > 0 {
+			def["required"] = required
+		}
+		if t == reflect.TypeOf(Block{}) {
+			def["minProperties"] = 1
+			def["maxProperties"] = 1
+		}
+		return ref
+	}
+	panic(fmt.Sprintf("document: no schema for %s", t))
+}
+
+// Returns the JSON name of a field and whether it is optional.
+func jsonName(f reflect.StructField) (string, bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" || !f.IsExported() {
+		return "", false
+	}
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = f.Name
+	}
+	return name, strings.Contains(opts, "omitempty")
+}
+
+// Checks the values of a struct against its enum, min and max tags. Zero
+// values are not checked, they select the default.
+func checkTags(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return checkTags(v.Elem())
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := checkTags(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+	default:
+		return nil
+	}
+	for _, f := range reflect.VisibleFields(v.Type()) {
+		name, _ := jsonName(f)
+		if name == "" {
+			continue
+		}
+		fv := v.FieldByIndex(f.Index)
+		if fv.IsZero() {
+			continue
+		}
+		if enum := f.Tag.Get("enum"); enum != "" && fv.Kind() == reflect.String {
+			if !contains(strings.Split(enum, ","), fv.String()) {
+				return fmt.Errorf("%s must be one of %s, got %q", name, strings.ReplaceAll(enum, ",", ", "), fv.String())
+			}
+		}
+		if fv.CanUint() {
+			if min, err := strconv.ParseUint(f.Tag.Get("min"), 10, 64); err == nil && fv.Uint() < min {
+				return fmt.Errorf("%s must be at least %d, got %d", name, min, fv.Uint())
+			}
+			if max, err := strconv.ParseUint(f.Tag.Get("max"), 10, 64); err == nil && fv.Uint() > max {
+				return fmt.Errorf("%s must be at most %d, got %d", name, max, fv.Uint())
+			}
+		}
+		if err := checkTags(fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package escpos
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/qiniu/iconv"
+)
+
+// CodePage is a character code table of the printer.
+type CodePage struct {
+	Table   uint8  // table number selected with ESC t
+	Charset string // iconv name of the matching character set
+}
+
+// CodePages holds the character code tables common to Epson compatible printers, by name.
+var CodePages = map[string]CodePage{
+	"pc437":   {Table: 0, Charset: "cp437"},
+	"pc850":   {Table: 2, Charset: "cp850"},
+	"pc860":   {Table: 3, Charset: "cp860"},
+	"pc863":   {Table: 4, Charset: "cp863"},
+	"pc865":   {Table: 5, Charset: "cp865"},
+	"wpc1252": {Table: 16, Charset: "cp1252"},
+	"pc866":   {Table: 17, Charset: "cp866"},
+	"pc852":   {Table: 18, Charset: "cp852"},
+	"pc858":   {Table: 19, Charset: "cp858"},
+}
+
+// Selects a character code table by name, see CodePages. Text passed to Write
+// is converted from UTF-8 to the code page from then on. Characters the code
+// page lacks are approximated where possible.
+func (e *Escpos) SetCodePage(name string) (int, error) {
+	cp, ok := CodePages[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown code page %q", name)
+	}
+	e.charset = cp.Charset
+	return e.WriteRaw([]byte{esc, 't', cp.Table})
+}
+
+// Converts UTF-8 text to the selected code page. Bytes that are not valid
+// UTF-8 are passed through unchanged.
+func (e *Escpos) encode(data []byte) []byte {
+	if e.charset == "" {
+		return data
+	}
+	cd, err := iconv.Open(e.charset+"//TRANSLIT", "utf-8")
+	if err != nil {
+		return data
+	}
+	defer cd.Close()
+	out := make([]byte, 0, len(data))
+	for len(data) > 0 {
+		// convert runs of valid UTF-8 and copy everything else
+		n := validUTF8Prefix(data)
+		if n > 0 {
+			converted := cd.ConvString(string(data[:n]))
+			if converted == "" {
+				converted = strings.Repeat("?", len([]rune(string(data[:n]))))
+			}
+			out = append(out, converted...)
+			data = data[n:]
+			continue
+		}
+		out = append(out, data[0])
+		data = data[1:]
+	}
+	return out
+}
+
+// Returns the length of the longest prefix of data that is valid UTF-8.
+func validUTF8Prefix(data []byte) int {
+	n := 0
+	for n < len(data) {
+		r, size := utf8.DecodeRune(data[n:])
+		if r == utf8.RuneError && size <= 1 {
+			break
+		}
+		n += size
+	}
+	return n
+}
@@ -1,7 +1,15 @@
 package escpos
 
 var (
-	ConfigEpsonTMT20II = PrinterConfig{}
-	ConfigEpsonTMT88II = PrinterConfig{DisableUpsideDown: true}
+	ConfigEpsonTMT20II = PrinterConfig{DotsPerLine: 576}
+	ConfigEpsonTMT88II = PrinterConfig{DisableUpsideDown: true, DotsPerLine: 512}
 	ConfigSOL802       = PrinterConfig{DisableUpsideDown: true}
 )
+
+// Profiles holds the printer configurations by name, for selecting one from
+// configuration files and markdown front matter.
+var Profiles = map[string]PrinterConfig{
+	"epson-tm-t20ii": ConfigEpsonTMT20II,
+	"epson-tm-t88ii": ConfigEpsonTMT88II,
+	"sol-802":        ConfigSOL802,
+}
@@ -0,0 +1,411 @@
+package escpos
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindDirective is the NodeKind of a Directive.
+var KindDirective = ast.NewNodeKind("Directive")
+
+// Directive is a markdown block of the form ":::name [option=value ...] [data]".
+// Directives that take data, like qr and barcode, can instead be fenced: the
+// lines up to a closing ":::" are the data.
+type Directive struct {
+	ast.BaseBlock
+	Name    string
+	Options map[string]string
+	Data    string
+	fenced  bool
+}
+
+// Kind implements ast.Node.Kind.
+func (n *Directive) Kind() ast.NodeKind {
+	return KindDirective
+}
+
+// IsRaw implements ast.Node.IsRaw. The data of a directive is not parsed as markdown.
+func (n *Directive) IsRaw() bool {
+	return true
+}
+
+// Dump implements ast.Node.Dump.
+func (n *Directive) Dump(source []byte, level int) {
+	kv := map[string]string{"Name": n.Name, "Data": n.Data}
+	for k, v := range n.Options {
+		kv[k] = v
+	}
+	ast.DumpHelper(n, source, level, kv, nil)
+}
+
+// Options each directive accepts before its data.
+var directiveOptions = map[string][]string{
+	"qr":      {"size", "level", "model"},
+	"barcode": {"type", "height", "width", "hri"},
+	"cut":     nil,
+	"feed":    nil,
+	"drawer":  {"pin"},
+}
+
+type directiveParser struct{}
+
+func (b *directiveParser) Trigger() []byte {
+	return []byte{':'}
+}
+
+func (b *directiveParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], []byte(":::")) {
+		return nil, parser.NoChildren
+	}
+	fields := strings.Fields(string(line[pos+3:]))
+	if len(fields) == 0 {
+		return nil, parser.NoChildren
+	}
+	name := strings.ToLower(fields[0])
+	known, ok := directiveOptions[name]
+	if !ok {
+		return nil, parser.NoChildren
+	}
+
+	node := &Directive{Name: name, Options: map[string]string{}}
+	args := fields[1:]
+	for len(args) > 0 {
+		key, value, found := strings.Cut(args[0], "=")
+		if !found || !contains(known, key) {
+			break
+		}
+		node.Options[key] = strings.Trim(value, `"`)
+		args = args[1:]
+	}
+	node.Data = strings.Join(args, " ")
+	node.fenced = node.Data == "" && (name == "qr" || name == "barcode")
+	node.Lines().Append(segment)
+	reader.AdvanceToEOL()
+	return node, parser.NoChildren
+}
+
+func (b *directiveParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	n := node.(*Directive)
+	if !n.fenced {
+		return parser.Close
+	}
+	line, segment := reader.PeekLine()
+	if line == nil {
+		return parser.Close
+	}
+	if bytes.Equal(util.TrimRightSpace(util.TrimLeftSpace(line)), []byte(":::")) {
+		reader.AdvanceToEOL()
+		return parser.Close
+	}
+	n.Lines().Append(segment)
+	reader.AdvanceToEOL()
+	return parser.Continue | parser.NoChildren
+}
+
+func (b *directiveParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {
+	n := node.(*Directive)
+	if n.fenced {
+		// The first line is the directive itself
+		var data bytes.Buffer
+		lines := n.Lines()
+		for i := 1; i < lines.Len(); i++ {
+			segment := lines.At(i)
+			data.Write(segment.Value(reader.Source()))
+		}
+		n.Data = strings.TrimSpace(data.String())
+	}
+}
+
+func (b *directiveParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (b *directiveParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// attributeTransformer moves a trailing attribute list like "{: .center}" from
+// the last line of a paragraph to the paragraph's attributes. An attribute list
+// on a line of its own after a block applies to that block.
+type attributeTransformer struct{}
+
+func (t *attributeTransformer) Transform(node *ast.Paragraph, reader text.Reader, pc parser.Context) {
+	lines := node.Lines()
+	if lines.Len() == 0 {
+		return
+	}
+	last := lines.At(lines.Len() - 1)
+	line := util.TrimRightSpace(last.Value(reader.Source()))
+	if len(line) == 0 || line[len(line)-1] != '}' {
+		return
+	}
+	start := bytes.LastIndexByte(line, '{')
+	if start < 0 {
+		return
+	}
+	attrs, ok := parseAttributeList(string(line[start+1 : len(line)-1]))
+	if !ok {
+		return
+	}
+
+	var target ast.Node = node
+	if rest := util.TrimRightSpace(line[:start]); len(rest) > 0 {
+		lines.Set(lines.Len()-1, last.WithStop(last.Start+len(rest)))
+	} else if lines.Len() > 1 {
+		lines.SetSliced(0, lines.Len()-1)
+	} else {
+		target = node.PreviousSibling()
+		node.Parent().RemoveChild(node.Parent(), node)
+	}
+	if target == nil {
+		return
+	}
+	for _, attr := range attrs {
+		if string(attr.Name) == "class" {
+			if class, ok := target.AttributeString("class"); ok {
+				attr.Value = []byte(attributeValue(class) + " " + attributeValue(attr.Value))
+			}
+		}
+		target.SetAttribute(attr.Name, attr.Value)
+	}
+}
+
+// Parses the inside of an attribute list: an optional leading ":" followed
+// by ".class", "#id" and "key=value" entries separated by spaces.
+func parseAttributeList(list string) (parser.Attributes, bool) {
+	list = strings.TrimPrefix(strings.TrimSpace(list), ":")
+	var attrs parser.Attributes
+	for _, field := range strings.Fields(list) {
+		switch {
+		case strings.HasPrefix(field, ".") && len(field) > 1:
+			attrs = append(attrs, parser.Attribute{Name: []byte("class"), Value: []byte(field[1:])})
+		case strings.HasPrefix(field, "#") && len(field) > 1:
+			attrs = append(attrs, parser.Attribute{Name: []byte("id"), Value: []byte(field[1:])})
+		case strings.Contains(field, "="):
+			key, value, _ := strings.Cut(field, "=")
+			if key == "" {
+				return nil, false
+			}
+			attrs = append(attrs, parser.Attribute{Name: []byte(key), Value: []byte(strings.Trim(value, `"`))})
+		default:
+			return nil, false
+		}
+	}
+	return attrs, len(attrs) > 0
+}
+
+// Applies the block attributes of a node to a style:
+//
+//	.left .center .right  justification
+//	.bold .underline      bold and underlined text
+//	.invert               white text on black
+//	size=2 or size=2x1    character width and height multipliers
+//	font=a or font=b      character font
+func styleFromAttributes(s Style, node ast.Node) Style {
+	if class, ok := node.AttributeString("class"); ok {
+		s = styleFromClasses(s, strings.Fields(attributeValue(class)))
+	}
+	if size, ok := node.AttributeString("size"); ok {
+		s = styleFromSize(s, attributeValue(size))
+	}
+	if font, ok := node.AttributeString("font"); ok {
+		s = styleFromFont(s, attributeValue(font))
+	}
+	return s
+}
+
+// Applies the style classes left, center, right, bold, underline and invert.
+func styleFromClasses(s Style, classes []string) Style {
+	for _, c := range classes {
+		switch c {
+		case "left":
+			s.Justify = JustifyLeft
+		case "center":
+			s.Justify = JustifyCenter
+		case "right":
+			s.Justify = JustifyRight
+		case "bold":
+			s.Bold = true
+		case "underline":
+			s.Underline = 1
+		case "invert":
+			s.Reverse = true
+		}
+	}
+	return s
+}
+
+// Applies a size of the form "2" or "2x1". Sizes out of range are ignored.
+func styleFromSize(s Style, size string) Style {
+	w, h, found := strings.Cut(size, "x")
+	if !found {
+		h = w
+	}
+	if width, err := strconv.Atoi(w); err == nil && width >= 1 && width <= 8 {
+		s.Width = uint8(width)
+	}
+	if height, err := strconv.Atoi(h); err == nil && height >= 1 && height <= 8 {
+		s.Height = uint8(height)
+	}
+	return s
+}
+
+// Applies a font of "a" or "b".
+func styleFromFont(s Style, font string) Style {
+	switch strings.ToLower(font) {
+	case "a":
+		s.Font = FontA
+	case "b":
+		s.Font = FontB
+	}
+	return s
+}
+
+// Returns an attribute value as a string. Attributes parsed by goldmark can be
+// numbers or booleans as well as []byte.
+func attributeValue(v interface{}) string {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Prints a directive.
+func (r *escr) renderDirective(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*Directive)
+	if _, err := r.p.WriteRaw([]byte{esc, 'a', r.style().Justify}); err != nil {
+		return ast.WalkStop, err
+	}
+
+	var err error
+	switch n.Name {
+	case "cut":
+		_, err = r.p.Cut()
+	case "feed":
+		lines := 1
+		if n.Data != "" {
+			lines, err = strconv.Atoi(n.Data)
+			if err != nil || lines < 0 || lines > 255 {
+				return ast.WalkStop, fmt.Errorf("feed needs a number of lines between 0 and 255, got %q", n.Data)
+			}
+		}
+		_, err = r.p.LineFeedD(uint8(lines))
+	case "drawer":
+		pin := uint8(2)
+		if n.Options["pin"] == "5" {
+			pin = 5
+		}
+		_, err = r.p.OpenDrawer(pin)
+	case "qr":
+		err = r.renderQRDirective(n)
+	case "barcode":
+		err = r.renderBarcodeDirective(n)
+	}
+	return ast.WalkSkipChildren, err
+}
+
+func (r *escr) renderQRDirective(n *Directive) error {
+	size := uint8(6)
+	if v, ok := n.Options["size"]; ok {
+		s, err := strconv.Atoi(v)
+		if err != nil || s < 1 || s > 16 {
+			return fmt.Errorf("qr size must be between 1 and 16, got %q", v)
+		}
+		size = uint8(s)
+	}
+	level := QRCodeErrorCorrectionLevelM
+	switch strings.ToUpper(n.Options["level"]) {
+	case "L":
+		level = QRCodeErrorCorrectionLevelL
+	case "Q":
+		level = QRCodeErrorCorrectionLevelQ
+	case "H":
+		level = QRCodeErrorCorrectionLevelH
+	}
+	if n.Data == "" {
+		return fmt.Errorf("qr needs data")
+	}
+	_, err := r.p.QRCode(n.Data, n.Options["model"] != "1", size, level)
+	return err
+}
+
+func (r *escr) renderBarcodeDirective(n *Directive) error {
+	if v, ok := n.Options["height"]; ok {
+		h, err := strconv.Atoi(v)
+		if err != nil || h < 1 || h > 255 {
+			return fmt.Errorf("barcode height must be between 1 and 255, got %q", v)
+		}
+		if _, err := r.p.BarcodeHeight(uint8(h)); err != nil {
+			return err
+		}
+	}
+	if v, ok := n.Options["width"]; ok {
+		w, err := strconv.Atoi(v)
+		if err != nil || w < 2 || w > 6 {
+			return fmt.Errorf("barcode width must be between 2 and 6, got %q", v)
+		}
+		if _, err := r.p.BarcodeWidth(uint8(w)); err != nil {
+			return err
+		}
+	}
+	hri := uint8(2)
+	switch n.Options["hri"] {
+	case "none":
+		hri = 0
+	case "above":
+		hri = 1
+	case "both":
+		hri = 3
+	}
+	if _, err := r.p.HRIPosition(hri); err != nil {
+		return err
+	}
+
+	var err error
+	switch strings.ToLower(n.Options["type"]) {
+	case "", "code128":
+		_, err = r.p.Code128(n.Data)
+	case "code39":
+		_, err = r.p.Code39(n.Data)
+	case "ean13":
+		_, err = r.p.EAN13(n.Data)
+	case "ean8":
+		_, err = r.p.EAN8(n.Data)
+	case "upca":
+		_, err = r.p.UPCA(n.Data)
+	case "upce":
+		_, err = r.p.UPCE(n.Data)
+	default:
+		return fmt.Errorf("unknown barcode type %q", n.Options["type"])
+	}
+	if err != nil {
+		return err
+	}
+	_, err = r.p.WriteRaw([]byte{'\n'})
+	return err
+}
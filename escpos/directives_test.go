@@ -0,0 +1,185 @@
+package escpos
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Parses markdown like WriteMarkdown does.
+func parseMarkdown(source string) ast.Node {
+	md := goldmark.New(
+		goldmark.WithExtensions(extension.Table),
+		goldmark.WithParserOptions(
+			parser.WithAttribute(),
+			parser.WithBlockParsers(util.Prioritized(&directiveParser{}, 50)),
+			parser.WithParagraphTransformers(util.Prioritized(&attributeTransformer{}, 50)),
+		),
+	)
+	return md.Parser().Parse(text.NewReader([]byte(source)))
+}
+
+func TestDirectiveParser(t *testing.T) {
+	for _, tt := range []struct {
+		markdown string
+		want     []Directive // nil if it isn't a directive
+	}{
+		{":::cut", []Directive{{Name: "cut"}}},
+		{":::feed 3", []Directive{{Name: "feed", Data: "3"}}},
+		{":::drawer pin=5", []Directive{{Name: "drawer", Options: map[string]string{"pin": "5"}}}},
+		{
+			":::qr size=6 level=H https://example.com/?a=b",
+			[]Directive{{Name: "qr", Options: map[string]string{"size": "6", "level": "H"}, Data: "https://example.com/?a=b"}},
+		},
+		{
+			`:::barcode type=ean13 hri="none" 400638133393`,
+			[]Directive{{Name: "barcode", Options: map[string]string{"type": "ean13", "hri": "none"}, Data: "400638133393"}},
+		},
+		// Options end at the first word that isn't one
+		{":::qr text size=6", []Directive{{Name: "qr", Data: "text size=6"}}},
+		{":::qr url=x", []Directive{{Name: "qr", Data: "url=x"}}},
+		{":::QR data", []Directive{{Name: "qr", Data: "data"}}},
+		{":::qr\nmulti\nline\n:::\nafter", []Directive{{Name: "qr", Data: "multi\nline"}}},
+		// An unclosed fence ends with the document
+		{":::barcode\n12345", []Directive{{Name: "barcode", Data: "12345"}}},
+		{"Text\n:::cut\n:::feed", []Directive{{Name: "cut"}, {Name: "feed"}}},
+		{":::unknown data", nil},
+		{":::", nil},
+		{"::cut", nil},
+	} {
+		var got []Directive
+		ast.Walk(parseMarkdown(tt.markdown), func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+			if d, ok := n.(*Directive); ok && entering {
+				got = append(got, Directive{Name: d.Name, Options: d.Options, Data: d.Data})
+			}
+			return ast.WalkContinue, nil
+		})
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: got %d directives, want %d", tt.markdown, len(got), len(tt.want))
+			continue
+		}
+		for i, d := range got {
+			want := tt.want[i]
+			if d.Name != want.Name || d.Data != want.Data || !maps.Equal(d.Options, want.Options) {
+				t.Errorf("%q: got %s %v %q, want %s %v %q", tt.markdown, d.Name, d.Options, d.Data, want.Name, want.Options, want.Data)
+			}
+		}
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	base := Style{Width: 1, Height: 1}
+	for _, tt := range []struct {
+		markdown string
+		kind     ast.NodeKind // of the first block
+		text     string       // of the first block
+		want     Style
+	}{
+		{"Centered {: .center}", ast.KindParagraph, "Centered", Style{Width: 1, Height: 1, Justify: JustifyCenter}},
+		{"Text\nmore\n{: .right .bold}", ast.KindParagraph, "Text\nmore", Style{Width: 1, Height: 1, Justify: JustifyRight, Bold: true}},
+		{"Big {: size=2x1 font=b .invert}", ast.KindParagraph, "Big", Style{Width: 2, Height: 1, Font: FontB, Reverse: true}},
+		{"Square {: size=3 .underline}", ast.KindParagraph, "Square", Style{Width: 3, Height: 3, Underline: 1}},
+		{"Too big {: size=9x2}", ast.KindParagraph, "Too big", Style{Width: 1, Height: 2}},
+		// On a line of its own it applies to the block before
+		{"# Title\n{: .center}", ast.KindHeading, "Title", Style{Width: 1, Height: 1, Justify: JustifyCenter}},
+		// Not an attribute list
+		{"Set {x}", ast.KindParagraph, "Set {x}", base},
+		{"Empty {}", ast.KindParagraph, "Empty {}", base},
+	} {
+		doc := parseMarkdown(tt.markdown)
+		n := doc.FirstChild()
+		if n == nil || n.Kind() != tt.kind {
+			t.Errorf("%q: got %v, want a %s", tt.markdown, n, tt.kind)
+			continue
+		}
+		var lines []string
+		for i := 0; i < n.Lines().Len(); i++ {
+			segment := n.Lines().At(i)
+			lines = append(lines, strings.TrimRight(string(segment.Value([]byte(tt.markdown))), "\n"))
+		}
+		if got := strings.Join(lines, "\n"); got != tt.text {
+			t.Errorf("%q: got text %q, want %q", tt.markdown, got, tt.text)
+		}
+		if got := styleFromAttributes(base, n); got != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.markdown, got, tt.want)
+		}
+	}
+}
+
+func TestRenderDirective(t *testing.T) {
+	for _, tt := range []struct {
+		markdown string
+		want     string
+	}{
+		{":::cut", "\x1ba\x00\x1dVA\x00"},
+		{":::feed 3", "\x1ba\x00\x1bd\x03"},
+		{":::feed", "\x1ba\x00\x1bd\x01"},
+		{":::drawer", "\x1ba\x00\x1bp\x00\x32\xfa"},
+		{":::drawer pin=5", "\x1ba\x00\x1bp\x01\x32\xfa"},
+		{
+			":::barcode width=3 height=80 hri=none 12345",
+			"\x1ba\x00\x1dh\x50\x1dw\x03\x1dH\x00\x1dk\x49\x07{B12345\n",
+		},
+		{":::barcode width=2 12345", "\x1ba\x00\x1dw\x02\x1dH\x02\x1dk\x49\x07{B12345\n"},
+		{":::barcode width=6 12345", "\x1ba\x00\x1dw\x06\x1dH\x02\x1dk\x49\x07{B12345\n"},
+		{":::barcode type=ean13 hri=both 400638133393", "\x1ba\x00\x1dH\x03\x1dk\x02400638133393\x00\n"},
+		{
+			":::qr size=4 level=H hi",
+			"\x1ba\x00\x1d(k\x04\x001A2\x00\x1d(k\x03\x001C\x04\x1d(k\x03\x001E3\x1d(k\x05\x001P0hi\x1d(k\x03\x001Q0",
+		},
+		{
+			":::qr\nmulti\nline\n:::",
+			"\x1ba\x00\x1d(k\x04\x001A2\x00\x1d(k\x03\x001C\x06\x1d(k\x03\x001E1\x1d(k\x0d\x001P0multi\nline\x1d(k\x03\x001Q0",
+		},
+	} {
+		var out bytes.Buffer
+		e := New(&out)
+		if _, err := e.WriteMarkdown([]byte(tt.markdown)); err != nil {
+			t.Errorf("%q: %v", tt.markdown, err)
+			continue
+		}
+		e.Print()
+		if got := out.String(); got != tt.want {
+			t.Errorf("%q:\ngot  %q\nwant %q", tt.markdown, got, tt.want)
+		}
+	}
+}
+
+func TestRenderDirectiveErrors(t *testing.T) {
+	for _, tt := range []struct {
+		markdown string
+		want     string
+	}{
+		{":::barcode width=1 12345", "barcode width must be between 2 and 6"},
+		{":::barcode width=7 12345", "barcode width must be between 2 and 6"},
+		{":::barcode width=wide 12345", "barcode width must be between 2 and 6"},
+		{":::barcode height=0 12345", "barcode height must be between 1 and 255"},
+		{":::barcode height=256 12345", "barcode height must be between 1 and 255"},
+		{":::barcode type=pdf417 12345", `unknown barcode type "pdf417"`},
+		{":::barcode type=ean13 123", "code should have a length between 12 and 13"},
+		{":::qr size=17 data", "qr size must be between 1 and 16"},
+		{":::qr\n:::", "qr needs data"},
+		{"Text\n\n:::feed 256", "feed needs a number of lines between 0 and 255"},
+	} {
+		var out bytes.Buffer
+		e := New(&out)
+		_, err := e.WriteMarkdown([]byte(tt.markdown))
+		var merr *MarkdownError
+		if !errors.As(err, &merr) || merr.Kind != KindDirective || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: got %v, want a directive error with %q", tt.markdown, err, tt.want)
+		}
+		e.Print()
+		if out.Len() > 0 {
+			t.Errorf("%q: wrote % x after an error", tt.markdown, out.Bytes())
+		}
+	}
+}
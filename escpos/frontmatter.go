@@ -0,0 +1,121 @@
+package escpos
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// FrontMatter holds the print options a markdown document can set in a YAML
+// block delimited by "---" lines, or a TOML block delimited by "+++" lines,
+// at the very start of the document.
+//
+//	---
+//	profile: epson-tm-t20ii
+//	paper_width: 80
+//	code_page: pc858
+//	cut: true
+//	copies: 2
+//	logo: logo.png
+//	footer: "Thank you for shopping with us {: .center}"
+//	---
+type FrontMatter struct {
+	Profile    string `yaml:"profile" toml:"profile"`         // name of a printer profile in Profiles
+	PaperWidth int    `yaml:"paper_width" toml:"paper_width"` // paper width in mm, 58 or 80
+	CodePage   string `yaml:"code_page" toml:"code_page"`     // name of a code page in CodePages
+	Cut        *bool  `yaml:"cut" toml:"cut"`                 // cut the paper after every copy
+	Copies     int    `yaml:"copies" toml:"copies"`           // number of copies, 1 if not set
+	Logo       string `yaml:"logo" toml:"logo"`               // image printed centered above the document
+	Footer     string `yaml:"footer" toml:"footer"`           // markdown printed below the document
+}
+
+// Most copies a document can ask for.
+const maxCopies = 100
+
+// Printable width in dots at 203 dpi, by paper width in mm.
+var paperWidthDots = map[int]uint16{
+	58: 384,
+	80: 576,
+}
+
+// ParseFrontMatter splits the front matter from a markdown document. It
+// returns nil and the unchanged document if there is no front matter.
+func ParseFrontMatter(markdown []byte) (*FrontMatter, []byte, error) {
+	var delim string
+	switch {
+	case hasDelimiterLine(markdown, "---"):
+		delim = "---"
+	case hasDelimiterLine(markdown, "+++"):
+		delim = "+++"
+	default:
+		return nil, markdown, nil
+	}
+	rest := markdown[bytes.IndexByte(markdown, '\n')+1:]
+
+	// find the closing delimiter
+	var header, body []byte
+	for pos := 0; pos <= len(rest); {
+		end := bytes.IndexByte(rest[pos:], '\n')
+		line := rest[pos:]
+		if end >= 0 {
+			line = rest[pos : pos+end]
+		}
+		if trimmed := string(bytes.TrimRight(line, " \t\r")); trimmed == delim || (delim == "---" && trimmed == "...") {
+			header = rest[:pos]
+			if end >= 0 {
+				body = rest[pos+end+1:]
+			}
+			break
+		}
+		if end < 0 {
+			return nil, nil, fmt.Errorf("front matter is not closed with %s", delim)
+		}
+		pos += end + 1
+	}
+
+	fm := &FrontMatter{}
+	var err error
+	if delim == "---" {
+		err = yaml.Unmarshal(header, fm)
+	} else {
+		err = toml.Unmarshal(header, fm)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse front matter: %w", err)
+	}
+	return fm, body, nil
+}
+
+func hasDelimiterLine(markdown []byte, delim string) bool {
+	line, _, _ := bytes.Cut(markdown, []byte("\n"))
+	return string(bytes.TrimRight(line, " \t\r")) == delim
+}
+
+// Applies the printer settings of the front matter.
+func (e *Escpos) applyFrontMatter(fm *FrontMatter) error {
+	if fm.Profile != "" {
+		conf, ok := Profiles[fm.Profile]
+		if !ok {
+			return fmt.Errorf("unknown printer profile %q", fm.Profile)
+		}
+		e.SetConfig(conf)
+	}
+	if fm.PaperWidth != 0 {
+		dots, ok := paperWidthDots[fm.PaperWidth]
+		if !ok {
+			return fmt.Errorf("unsupported paper width %dmm, use 58 or 80", fm.PaperWidth)
+		}
+		e.config.DotsPerLine = dots
+	}
+	if fm.CodePage != "" {
+		if _, err := e.SetCodePage(fm.CodePage); err != nil {
+			return err
+		}
+	}
+	if fm.Copies < 0 || fm.Copies > maxCopies {
+		return fmt.Errorf("copies must be between 1 and %d, got %d", maxCopies, fm.Copies)
+	}
+	return nil
+}
@@ -0,0 +1,453 @@
+package escpos
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// HTMLError is returned by WriteHTML when an element can't be printed.
+type HTMLError struct {
+	Element string // tag name of the element that failed
+	Err     error
+}
+
+func (e *HTMLError) Error() string {
+	return fmt.Sprintf("failed to print html <%s>: %v", e.Element, e.Err)
+}
+
+func (e *HTMLError) Unwrap() error {
+	return e.Err
+}
+
+// Elements that start and end a block of text.
+var htmlBlocks = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true, atom.Blockquote: true,
+	atom.Body: true, atom.Caption: true, atom.Center: true, atom.Dd: true,
+	atom.Details: true, atom.Div: true, atom.Dl: true, atom.Dt: true,
+	atom.Figcaption: true, atom.Figure: true, atom.Footer: true, atom.Form: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Header: true, atom.Li: true, atom.Main: true, atom.Nav: true, atom.Ol: true,
+	atom.P: true, atom.Pre: true, atom.Section: true, atom.Summary: true, atom.Ul: true,
+}
+
+// Elements whose content is not printed.
+var htmlHidden = map[atom.Atom]bool{
+	atom.Head: true, atom.Noscript: true, atom.Script: true, atom.Style: true,
+	atom.Template: true, atom.Title: true,
+}
+
+// WriteHTML renders an HTML document or fragment and writes it to the
+// printer. It understands headings, paragraphs, lists, b/strong, u, em,
+// code, pre, links, img, hr and tables. Other elements are printed as plain
+// text. Styles come from the classes left, center, right, bold, underline,
+// invert, size-N or size-WxH and font-a or font-b, and from the CSS
+// properties text-align, font-weight, text-decoration and font-size. The
+// markdown options for links, images and tables apply as well. The returned
+// count is the number of bytes written to the printer.
+func (e *Escpos) WriteHTML(doc []byte, opts ...MarkdownOption) (int, error) {
+	var o markdownOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	root, err := html.Parse(bytes.NewReader(doc))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse html: %w", err)
+	}
+
+	var buf bytes.Buffer
+	p := e.derive(&buf)
+	r := newMarkdownRenderer(p, o)
+	if err := r.renderHTMLNode(root); err != nil {
+		return 0, err
+	}
+	if err := r.flushBlock(); err != nil {
+		return 0, err
+	}
+	if err := r.writeFootnotes(); err != nil {
+		return 0, err
+	}
+	if err := p.Print(); err != nil {
+		return 0, err
+	}
+	e.Layout = p.Layout
+	return e.WriteRaw(buf.Bytes())
+}
+
+func (r *escr) renderHTMLNode(n *html.Node) error {
+	switch n.Type {
+	case html.TextNode:
+		r.appendHTMLText(n.Data)
+		return nil
+	case html.ElementNode:
+	default:
+		return r.renderHTMLChildren(n)
+	}
+	if htmlHidden[n.DataAtom] {
+		return nil
+	}
+	err := r.renderHTMLElement(n)
+	var htmlErr *HTMLError
+	if err != nil && !errors.As(err, &htmlErr) {
+		err = &HTMLError{Element: n.Data, Err: err}
+	}
+	return err
+}
+
+func (r *escr) renderHTMLChildren(n *html.Node) error {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if err := r.renderHTMLNode(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *escr) renderHTMLElement(n *html.Node) error {
+	switch n.DataAtom {
+	case atom.Br:
+		r.appendText("\n")
+		return nil
+	case atom.Hr:
+		if err := r.flushBlock(); err != nil {
+			return err
+		}
+		return r.writeRule()
+	case atom.Img:
+		// Tables are printed after all their cells are collected, so
+		// images in them are left to their alt text
+		if r.table != nil {
+			r.appendHTMLText(htmlAttr(n, "alt"))
+			return nil
+		}
+		printed, err := r.printImage(htmlAttr(n, "src"))
+		if !printed && err == nil {
+			r.appendHTMLText(htmlAttr(n, "alt"))
+		}
+		return err
+	case atom.Table:
+		if r.table == nil {
+			return r.renderHTMLTable(n)
+		}
+	}
+
+	block := htmlBlocks[n.DataAtom]
+	if block {
+		if err := r.flushBlock(); err != nil {
+			return err
+		}
+	}
+	r.pushStyle(htmlStyle(r.style(), n))
+	switch n.DataAtom {
+	case atom.Blockquote:
+		r.indents = append(r.indents, indentEntry{indent: []Span{{Text: "| ", Style: r.style()}}})
+	case atom.Li:
+		marker := htmlListMarker(n) + " "
+		r.indents = append(r.indents, indentEntry{
+			indent: []Span{{Text: strings.Repeat(" ", StringWidth(marker)), Style: r.style()}},
+			marker: []Span{{Text: marker, Style: r.style()}},
+		})
+	}
+
+	var err error
+	if n.DataAtom == atom.Pre {
+		r.appendText(strings.TrimSuffix(strings.TrimPrefix(htmlText(n), "\n"), "\n"))
+	} else {
+		err = r.renderHTMLChildren(n)
+	}
+	if err == nil && n.DataAtom == atom.A {
+		r.appendLink(htmlAttr(n, "href"), strings.TrimSpace(htmlText(n)))
+	}
+
+	if err == nil && block {
+		switch n.DataAtom {
+		case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+			if len(r.spans) > 0 {
+				err = r.flushHeading(r.style().Height)
+			}
+		default:
+			err = r.flushBlock()
+		}
+	}
+	switch n.DataAtom {
+	case atom.Blockquote:
+		r.indents = r.indents[:len(r.indents)-1]
+	case atom.Li:
+		// An empty item still gets its marker
+		if err == nil && r.indents[len(r.indents)-1].marker != nil {
+			err = r.flush()
+		}
+		r.indents = r.indents[:len(r.indents)-1]
+	}
+	r.popStyle()
+	return err
+}
+
+// Collects the rows of a table and prints it with WriteTable. Rows of th
+// cells at the start of the table are the header. Column alignment comes
+// from the first row.
+func (r *escr) renderHTMLTable(n *html.Node) error {
+	if err := r.flushBlock(); err != nil {
+		return err
+	}
+	r.pushStyle(htmlStyle(r.style(), n))
+	defer r.popStyle()
+	t := &Table{Border: r.opts.tableBorder, Truncate: r.opts.tableTruncate}
+	r.table = t
+	defer func() { r.table = nil }()
+
+	for _, tr := range htmlTableRows(n) {
+		var row []TableCell
+		header := true
+		for c := tr.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || (c.DataAtom != atom.Td && c.DataAtom != atom.Th) {
+				continue
+			}
+			header = header && c.DataAtom == atom.Th
+			style := htmlStyle(r.style(), c)
+			if len(row) >= len(t.Align) {
+				justify := style.Justify
+				if align := strings.ToLower(htmlAttr(c, "align")); align != "" {
+					justify = htmlJustify(align, justify)
+				}
+				t.Align = append(t.Align, justify)
+			}
+			r.pushStyle(style)
+			err := r.renderHTMLChildren(c)
+			r.popStyle()
+			if err != nil {
+				return err
+			}
+			row = append(row, r.spans)
+			r.spans = nil
+		}
+		if header && len(row) > 0 && len(t.Rows) == 0 && len(t.Header) == 0 {
+			t.Header = row
+		} else {
+			t.Rows = append(t.Rows, row)
+		}
+	}
+
+	saved := r.p.Style
+	r.p.Style = r.style()
+	r.p.Style.Justify = JustifyLeft
+	_, err := r.p.WriteTable(*t)
+	r.p.Style = saved
+	return err
+}
+
+// Returns the tr elements of a table, looking inside thead, tbody and tfoot
+// but not inside nested tables.
+func htmlTableRows(table *html.Node) []*html.Node {
+	var rows []*html.Node
+	for c := table.FirstChild; c != nil; c = c.NextSibling {
+		switch c.DataAtom {
+		case atom.Tr:
+			rows = append(rows, c)
+		case atom.Thead, atom.Tbody, atom.Tfoot:
+			rows = append(rows, htmlTableRows(c)...)
+		}
+	}
+	return rows
+}
+
+// Writes the collected inline content, if there is any.
+func (r *escr) flushBlock() error {
+	if len(r.spans) == 0 && len(r.qrCodes) == 0 {
+		return nil
+	}
+	return r.flush()
+}
+
+// Adds text with runs of white space collapsed to a single space, as a
+// browser would. Space at the start of a line is dropped.
+func (r *escr) appendHTMLText(text string) {
+	var b strings.Builder
+	space := len(r.spans) == 0 || strings.HasSuffix(r.spans[len(r.spans)-1].Text, " ") ||
+		strings.HasSuffix(r.spans[len(r.spans)-1].Text, "\n")
+	for _, c := range text {
+		switch c {
+		case ' ', '\t', '\n', '\r', '\f':
+			if !space {
+				b.WriteByte(' ')
+			}
+			space = true
+		default:
+			b.WriteRune(c)
+			space = false
+		}
+	}
+	if b.Len() > 0 {
+		r.appendText(b.String())
+	}
+}
+
+// Returns the style of an element: the defaults of its tag, then its classes
+// and then its style attribute.
+func htmlStyle(s Style, n *html.Node) Style {
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		s.Width = uint8(7 - level)
+		s.Height = s.Width
+	case atom.B, atom.Strong:
+		s.Bold = true
+	case atom.U, atom.Ins, atom.Em, atom.I:
+		s.Underline = 1
+	case atom.Code, atom.Kbd, atom.Samp, atom.Tt, atom.Pre, atom.Small:
+		s.Font = FontB
+	case atom.Mark:
+		s.Reverse = true
+	case atom.Center:
+		s.Justify = JustifyCenter
+	}
+
+	for _, class := range strings.Fields(htmlAttr(n, "class")) {
+		switch {
+		case strings.HasPrefix(class, "size-"):
+			s = styleFromSize(s, strings.TrimPrefix(class, "size-"))
+		case strings.HasPrefix(class, "font-"):
+			s = styleFromFont(s, strings.TrimPrefix(class, "font-"))
+		default:
+			s = styleFromClasses(s, []string{class})
+		}
+	}
+	if align := htmlAttr(n, "align"); align != "" && n.DataAtom != atom.Td && n.DataAtom != atom.Th {
+		s.Justify = htmlJustify(strings.ToLower(align), s.Justify)
+	}
+
+	for _, decl := range strings.Split(htmlAttr(n, "style"), ";") {
+		prop, value, ok := strings.Cut(decl, ":")
+		if !ok {
+			continue
+		}
+		value = strings.ToLower(strings.TrimSpace(value))
+		switch strings.ToLower(strings.TrimSpace(prop)) {
+		case "text-align":
+			s.Justify = htmlJustify(value, s.Justify)
+		case "font-weight":
+			weight, err := strconv.Atoi(value)
+			s.Bold = value == "bold" || value == "bolder" || (err == nil && weight >= 600)
+		case "text-decoration", "text-decoration-line":
+			if strings.Contains(value, "underline") {
+				s.Underline = 1
+			} else if value == "none" {
+				s.Underline = 0
+			}
+		case "font-size":
+			s = htmlFontSize(s, value)
+		}
+	}
+	return s
+}
+
+func htmlJustify(align string, def uint8) uint8 {
+	switch align {
+	case "left", "start":
+		return JustifyLeft
+	case "center":
+		return JustifyCenter
+	case "right", "end":
+		return JustifyRight
+	}
+	return def
+}
+
+// Maps a CSS font size to a size multiplier. Sizes below normal select Font B.
+func htmlFontSize(s Style, value string) Style {
+	var scale float64
+	switch value {
+	case "xx-small", "x-small", "small", "smaller":
+		s.Font = FontB
+		return s
+	case "medium":
+		scale = 1
+	case "large", "larger", "x-large":
+		scale = 2
+	case "xx-large":
+		scale = 3
+	case "xxx-large":
+		scale = 4
+	default:
+		var err error
+		switch {
+		case strings.HasSuffix(value, "em"):
+			scale, err = strconv.ParseFloat(strings.TrimSuffix(strings.TrimSuffix(value, "em"), "r"), 64)
+		case strings.HasSuffix(value, "%"):
+			scale, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+			scale /= 100
+		default:
+			return s
+		}
+		if err != nil {
+			return s
+		}
+		if scale < 1 {
+			s.Font = FontB
+			return s
+		}
+	}
+	size := uint8(min(max(scale+0.5, 1), 8))
+	s.Width, s.Height = size, size
+	return s
+}
+
+// Returns "1." style markers for items of ol elements and a bullet
+// depending on the nesting depth otherwise.
+func htmlListMarker(li *html.Node) string {
+	list := li.Parent
+	if list != nil && list.DataAtom == atom.Ol {
+		index := 1
+		if start, err := strconv.Atoi(htmlAttr(list, "start")); err == nil {
+			index = start
+		}
+		for c := list.FirstChild; c != nil && c != li; c = c.NextSibling {
+			if c.DataAtom == atom.Li {
+				index++
+			}
+		}
+		return fmt.Sprintf("%d.", index)
+	}
+	depth := 0
+	if list != nil {
+		for p := list.Parent; p != nil; p = p.Parent {
+			if p.DataAtom == atom.Ul || p.DataAtom == atom.Ol {
+				depth++
+			}
+		}
+	}
+	return listBullets[depth%len(listBullets)]
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// Returns the concatenated text below n.
+func htmlText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		if n.DataAtom == atom.Br {
+			b.WriteByte('\n')
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return b.String()
+}
@@ -0,0 +1,98 @@
+package escpos
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// Returns the style commands Write sends before text with the bold,
+// underline, justify, font and character size settings, the others default.
+func styled(bold, underline, justify, font, size byte) string {
+	return string([]byte{
+		esc, 'E', bold, esc, '-', underline, gs, 'B', 0, esc, 'V', 0, esc, '{', 0,
+		esc, 'a', justify, esc, 'M', font, esc, ' ', 0, gs, '!', size,
+	})
+}
+
+func TestWriteHTML(t *testing.T) {
+	// A black square of 8 by 8 dots and its raster
+	logo := "data:image/png;base64," + base64.StdEncoding.EncodeToString(encodePNG(t, 8, 8))
+	raster := "\x1ba\x00\x1dv0\x00\x01\x00\x08\x00" + strings.Repeat("\xff", 8)
+	for _, tt := range []struct {
+		name     string
+		html     string
+		dataURIs bool
+		want     string
+	}{
+		{
+			name: "nested inline styles",
+			html: `<p>a <b>bold <u>both</u></b> c</p>`,
+			want: plainStyle + "a " + boldStyle + "bold " + styled(1, 1, 0, 0, 0) + "both" + plainStyle + " c\n",
+		},
+		{
+			name: "inline style overridden",
+			html: `<p><i>em <b style="font-weight:normal">not bold</b></i></p>`,
+			want: styled(0, 1, 0, 0, 0) + "em not bold\n",
+		},
+		{
+			name: "css",
+			html: `<p style="text-align:center">x <span style="font-weight:bold; text-decoration: underline">y</span></p>`,
+			want: styled(0, 0, 1, 0, 0) + "x " + styled(1, 1, 1, 0, 0) + "y\n",
+		},
+		{
+			// Headings are printed 7 - level times the normal size
+			name: "classes",
+			html: `<h2 class="center">Title</h2><p class="size-2x1 font-b">Big</p>`,
+			want: styled(0, 0, 1, 0, 0x44) + "Title\n" + styled(0, 0, 0, 1, 0x10) + "Big\n",
+		},
+		{
+			name:     "image",
+			html:     `<p>Before</p><img alt="Logo" src="` + logo + `"><p>After</p>`,
+			dataURIs: true,
+			want:     plainStyle + "Before\n" + raster + plainStyle + "After\n",
+		},
+		{
+			name:     "image in text",
+			html:     `<p>Text <img alt="Logo" src="` + logo + `"> more</p>`,
+			dataURIs: true,
+			want:     plainStyle + "Text\n" + raster + plainStyle + "more\n",
+		},
+		{
+			name: "alt text of a missing image",
+			html: `<p><img alt="Logo" src="missing.png"> after</p>`,
+			want: plainStyle + "Logo after\n",
+		},
+		{
+			name: "alt text without data URIs",
+			html: `<p><img alt="Logo" src="` + logo + `"></p>`,
+			want: plainStyle + "Logo\n",
+		},
+		{
+			// Images in cells are printed as their alt text
+			name:     "table",
+			html:     `<table><tr><th>Item</th><th align="right">Price</th></tr><tr><td>Tea <img alt="cup" src="` + logo + `"></td><td>2.50</td></tr></table>`,
+			dataURIs: true,
+			want: at(0) + boldStyle + "Item" + at(96) + boldStyle + "Price\n" +
+				at(0) + plainStyle + "Tea cup" + at(108) + plainStyle + "2.50\n",
+		},
+		{
+			name: "table sections",
+			html: `<table><thead><tr><th>A</th><th>B</th></tr></thead><tbody><tr><td>1</td><td>2</td></tr></tbody></table>`,
+			want: at(0) + boldStyle + "A" + at(24) + boldStyle + "B\n" +
+				at(0) + plainStyle + "1" + at(24) + plainStyle + "2\n",
+		},
+	} {
+		var out bytes.Buffer
+		e := New(&out)
+		if _, err := e.WriteHTML([]byte(tt.html), WithDataURIImages(tt.dataURIs)); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		e.Print()
+		if got := out.String(); got != tt.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+}
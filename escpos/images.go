@@ -0,0 +1,125 @@
+package escpos
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"  // Register GIF decoder
+	_ "image/jpeg" // Register JPEG decoder
+	_ "image/png"  // Register PNG decoder
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Prints an image, scaled down to fit the print area if it is too wide.
+func (e *Escpos) PrintImageFit(img image.Image) (int, error) {
+	if width := e.LineWidth(); img.Bounds().Dx() > width {
+		img = scaleImage(img, width)
+	}
+	return e.PrintImage(img)
+}
+
+// Resizes img to width, keeping the aspect ratio. Every target pixel is the
+// average of the source pixels it covers, which keeps thin lines visible.
+func scaleImage(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	if width <= 0 || b.Dx() == 0 || b.Dy() == 0 {
+		return img
+	}
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		sy0 := b.Min.Y + y*b.Dy()/height
+		sy1 := max(b.Min.Y+(y+1)*b.Dy()/height, sy0+1)
+		for x := 0; x < width; x++ {
+			sx0 := b.Min.X + x*b.Dx()/width
+			sx1 := max(b.Min.X+(x+1)*b.Dx()/width, sx0+1)
+			var r, g, bl, a, n uint32
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+pr, g+pg, bl+pb, a+pa, n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// Loads an image from a local file or, if allowed, a data: URI. Relative paths
+// are resolved against dir. Remote URLs are not fetched.
+func loadImage(src, dir string, allowDataURI bool) (image.Image, error) {
+	if strings.HasPrefix(src, "data:") {
+		if !allowDataURI {
+			return nil, fmt.Errorf("data URIs are not enabled")
+		}
+		data, err := decodeDataURI(src)
+		if err != nil {
+			return nil, err
+		}
+		return DecodeImage(data)
+	}
+	if u, err := url.Parse(src); err == nil && u.Scheme != "" && u.Scheme != "file" && len(u.Scheme) > 1 {
+		return nil, fmt.Errorf("only local images can be printed, got %s", u.Scheme)
+	}
+	path := strings.TrimPrefix(src, "file://")
+	if !filepath.IsAbs(path) && dir != "" {
+		path = filepath.Join(dir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	img, err := DecodeImage(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", src, err)
+	}
+	return img, nil
+}
+
+// Limits of the images DecodeImage decodes: half a metre of paper at most,
+// and no more pixels than a 4096 by 4096 image.
+const (
+	MaxImageHeight = 4000
+	MaxImagePixels = 4096 * 4096
+)
+
+// Decodes a PNG, JPEG or GIF image of at most MaxImageHeight rows and
+// MaxImagePixels pixels. The size is checked before the image is decoded, so
+// a small file can't make it allocate a huge one. Wider images than the print
+// area are fine, PrintImageFit scales them down.
+func DecodeImage(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Height > MaxImageHeight {
+		return nil, fmt.Errorf("the image is %d pixels tall, more than %d", config.Height, MaxImageHeight)
+	}
+	if int64(config.Width)*int64(config.Height) > MaxImagePixels {
+		return nil, fmt.Errorf("the image has %dx%d pixels, more than %d", config.Width, config.Height, MaxImagePixels)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// Returns the payload of a data URI of the form data:[<mediatype>][;base64],<data>.
+func decodeDataURI(uri string) ([]byte, error) {
+	header, payload, ok := strings.Cut(strings.TrimPrefix(uri, "data:"), ",")
+	if !ok {
+		return nil, fmt.Errorf("malformed data URI")
+	}
+	if strings.HasSuffix(header, ";base64") {
+		return base64.StdEncoding.DecodeString(payload)
+	}
+	data, err := url.PathUnescape(payload)
+	return []byte(data), err
+}
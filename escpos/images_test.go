@@ -0,0 +1,81 @@
+package escpos
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// Returns the width in dots of the first raster image in data.
+func rasterWidth(t *testing.T, data []byte) int {
+	t.Helper()
+	i := bytes.Index(data, []byte{gs, 'v', 48, 0})
+	if i < 0 || i+8 > len(data) {
+		t.Fatalf("no raster image in % x", data)
+	}
+	return (int(data[i+4]) | int(data[i+5])<<8) * 8
+}
+
+func TestWideImagePrintsAtLineWidth(t *testing.T) {
+	data := encodePNG(t, 1000, 20)
+	for _, dots := range []uint16{384, 576} {
+		var out bytes.Buffer
+		e := New(&out)
+		e.SetConfig(PrinterConfig{DotsPerLine: dots})
+		img, err := DecodeImage(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.PrintImageFit(img); err != nil {
+			t.Fatal(err)
+		}
+		e.Print()
+		if got := rasterWidth(t, out.Bytes()); got != e.LineWidth() {
+			t.Errorf("%d dots: printed %d dots wide, want %d", dots, got, e.LineWidth())
+		}
+	}
+}
+
+func TestMarkdownImageScaledToLineWidth(t *testing.T) {
+	uri := "data:image/png;base64," + base64.StdEncoding.EncodeToString(encodePNG(t, 1000, 20))
+	var out bytes.Buffer
+	e := New(&out)
+	if _, err := e.WriteMarkdown([]byte("![wide]("+uri+")\n"), WithDataURIImages(true)); err != nil {
+		t.Fatal(err)
+	}
+	e.Print()
+	if bytes.Contains(out.Bytes(), []byte("wide")) {
+		t.Errorf("printed the alt text instead of the image")
+	}
+	if got := rasterWidth(t, out.Bytes()); got != e.LineWidth() {
+		t.Errorf("printed %d dots wide, want %d", got, e.LineWidth())
+	}
+}
+
+func TestDecodeImageLimits(t *testing.T) {
+	for _, tt := range []struct {
+		width, height int
+		ok            bool
+	}{
+		{1000, 20, true},
+		{8, MaxImageHeight, true},
+		{8, MaxImageHeight + 1, false},
+		{5000, 3500, false},
+	} {
+		_, err := DecodeImage(encodePNG(t, tt.width, tt.height))
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("%dx%d: got error %v", tt.width, tt.height, err)
+		}
+	}
+}
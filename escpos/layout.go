@@ -0,0 +1,366 @@
+package escpos
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Span is a piece of text printed with a single style.
+type Span struct {
+	Text  string
+	Style Style
+}
+
+// Line is a laid out line of spans that fits the print area.
+type Line []Span
+
+// Returns the text of the line without its styles.
+func (l Line) String() string {
+	var b strings.Builder
+	for _, s := range l {
+		b.WriteString(s.Text)
+	}
+	return b.String()
+}
+
+// WrapOptions controls how text is broken into lines.
+type WrapOptions struct {
+	// Indent is printed at the start of every line, e.g. spaces for nesting or a quote bar.
+	Indent []Span
+	// Prefix is printed after Indent on the first line only, e.g. a list marker.
+	// Following lines are indented by its width so text hangs under the first line.
+	Prefix []Span
+	// Hyphenate adds a hyphen when a word longer than the line has to be broken.
+	// Otherwise the word is broken at the last character that fits.
+	Hyphenate bool
+}
+
+// Returns the width of the print area in dots, taking the left margin and print area width into account.
+func (e *Escpos) LineWidth() int {
+	if e.Layout.PrintAreaWidth > 0 {
+		return int(e.Layout.PrintAreaWidth)
+	}
+	if e.Layout.LeftMargin >= e.DotsPerLine() {
+		return 0
+	}
+	return int(e.DotsPerLine() - e.Layout.LeftMargin)
+}
+
+// Returns the width in dots of a single character cell printed with style s.
+func (e *Escpos) CellWidth(s Style) int {
+	return (fontDotWidth(s.Font) + int(s.CharSpacing)) * multiplier(s.Width)
+}
+
+// Returns the number of characters of the current style that fit on a line.
+func (e *Escpos) Columns() int {
+	return e.LineWidth() / e.CellWidth(e.Style)
+}
+
+// Returns the number of character cells r occupies: 2 for East Asian wide and
+// fullwidth runes, 0 for combining marks and control characters and 1 otherwise.
+func RuneWidth(r rune) int {
+	switch {
+	case r == 0 || unicode.IsControl(r) || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r):
+		return 0
+	case isWide(r):
+		return 2
+	}
+	return 1
+}
+
+// Returns the number of character cells s occupies.
+func StringWidth(s string) int {
+	w := 0
+	for _, r := range s {
+		w += RuneWidth(r)
+	}
+	return w
+}
+
+// Breaks spans into lines that fit the print area. Words are kept whole where
+// possible, explicit newlines start a new line and wide runes may be broken
+// between any two characters.
+func (e *Escpos) Wrap(spans []Span, opts WrapOptions) []Line {
+	return e.WrapWidth(spans, opts, e.LineWidth())
+}
+
+// Breaks spans into lines no wider than width dots, like Wrap does for the
+// print area. It is for text that shares the line with other columns.
+func (e *Escpos) WrapWidth(spans []Span, opts WrapOptions, width int) []Line {
+	return wrapMeasured(spans, opts, width, e.spanWidth)
+}
+
+// Breaks spans into lines no wider than width, measuring spans with measure.
+func wrapMeasured(spans []Span, opts WrapOptions, width int, measure func(Span) int) []Line {
+	l := &lineBreaker{measure: measure, opts: opts, avail: width}
+	l.startLine(true)
+	for _, tok := range tokenize(spans) {
+		switch tok.kind {
+		case tokenNewline:
+			l.endLine()
+			l.startLine(false)
+		case tokenSpace:
+			if !l.wrapped || l.width > l.indentWidth {
+				l.pending = append(l.pending, tok.frags...)
+			}
+		case tokenWord:
+			l.placeWord(tok.frags)
+		}
+	}
+	l.endLine()
+	return l.lines
+}
+
+// Lays out spans with Wrap and writes them line by line. The style in effect
+// before the call is restored afterwards.
+func (e *Escpos) WriteSpans(spans []Span, opts WrapOptions) (int, error) {
+	saved := e.Style
+	defer func() { e.Style = saved }()
+
+	written := 0
+	for _, line := range e.Wrap(spans, opts) {
+		n, err := e.writeSpansLine(line)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if _, err := e.WriteRaw([]byte{'\n'}); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Writes spans as they are, without wrapping or ending the line.
+func (e *Escpos) writeSpansLine(spans []Span) (int, error) {
+	saved := e.Style
+	defer func() { e.Style = saved }()
+
+	written := 0
+	for _, span := range spans {
+		e.Style = span.Style
+		n, err := e.Write(span.Text)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Writes text in the current style, wrapped on word boundaries.
+func (e *Escpos) WriteWrapped(text string) (int, error) {
+	return e.WriteSpans([]Span{{Text: text, Style: e.Style}}, WrapOptions{})
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenSpace
+	tokenNewline
+)
+
+// A token is a run of words, spaces or a newline. Words can consist of
+// several fragments when the style changes in the middle of a word.
+type token struct {
+	kind  tokenKind
+	frags []Span
+}
+
+func tokenize(spans []Span) []token {
+	var tokens []token
+	add := func(kind tokenKind, r rune, style Style) {
+		last := len(tokens) - 1
+		// Wide runes are tokens of their own so lines can be broken between them.
+		glue := last >= 0 && tokens[last].kind == kind && kind != tokenNewline &&
+			!(kind == tokenWord && (isWide(r) || lastRuneIsWide(tokens[last])))
+		if !glue {
+			tokens = append(tokens, token{kind: kind})
+			last++
+		}
+		frags := tokens[last].frags
+		if n := len(frags); n > 0 && frags[n-1].Style == style {
+			frags[n-1].Text += string(r)
+		} else {
+			tokens[last].frags = append(frags, Span{Text: string(r), Style: style})
+		}
+	}
+	for _, span := range spans {
+		for _, r := range span.Text {
+			switch {
+			case r == '\n':
+				add(tokenNewline, r, span.Style)
+			case r == '\r':
+			case r == '\t':
+				add(tokenSpace, ' ', span.Style)
+			case unicode.IsSpace(r) && r != '\u00a0':
+				add(tokenSpace, r, span.Style)
+			default:
+				add(tokenWord, r, span.Style)
+			}
+		}
+	}
+	return tokens
+}
+
+func lastRuneIsWide(t token) bool {
+	if len(t.frags) == 0 {
+		return false
+	}
+	r, _ := utf8.DecodeLastRuneInString(t.frags[len(t.frags)-1].Text)
+	return isWide(r)
+}
+
+type lineBreaker struct {
+	measure func(Span) int // width of a span in dots
+	opts    WrapOptions
+	avail   int
+
+	lines       []Line
+	current     Line
+	width       int    // width of current in dots
+	indentWidth int    // width of the indent and prefix on the current line
+	pending     []Span // spaces waiting for the next word
+	wrapped     bool   // whether the current line continues the previous one
+}
+
+func (l *lineBreaker) startLine(first bool) {
+	l.current = nil
+	l.width = 0
+	l.pending = nil
+	l.wrapped = false
+	l.appendSpans(l.opts.Indent)
+	if first {
+		l.appendSpans(l.opts.Prefix)
+	} else if len(l.opts.Prefix) > 0 {
+		pad := l.spansWidth(l.opts.Prefix)
+		style := l.opts.Prefix[len(l.opts.Prefix)-1].Style
+		if space := l.measure(Span{Text: " ", Style: style}); space > 0 {
+			l.appendSpans([]Span{{Text: strings.Repeat(" ", pad/space), Style: style}})
+		}
+	}
+	l.indentWidth = l.width
+}
+
+func (l *lineBreaker) endLine() {
+	l.lines = append(l.lines, l.current)
+}
+
+func (l *lineBreaker) wrap() {
+	l.endLine()
+	l.startLine(false)
+	l.wrapped = true
+}
+
+func (l *lineBreaker) placeWord(frags []Span) {
+	width := l.spansWidth(frags)
+	spaces := l.spansWidth(l.pending)
+	if l.width+spaces+width <= l.avail {
+		l.appendSpans(l.pending)
+		l.appendSpans(frags)
+		l.pending = nil
+		return
+	}
+	if l.width > l.indentWidth {
+		l.wrap()
+		l.placeWord(frags)
+		return
+	}
+	// The word does not fit on an empty line, so break it.
+	l.pending = nil
+	l.breakWord(frags)
+}
+
+func (l *lineBreaker) breakWord(frags []Span) {
+	for _, frag := range frags {
+		reserve := 0
+		if l.opts.Hyphenate {
+			reserve = l.measure(Span{Text: "-", Style: frag.Style})
+		}
+		for _, r := range frag.Text {
+			w := l.measure(Span{Text: string(r), Style: frag.Style})
+			if l.width+w+reserve > l.avail && l.width > l.indentWidth {
+				if l.opts.Hyphenate {
+					l.appendSpans([]Span{{Text: "-", Style: frag.Style}})
+				}
+				l.wrap()
+			}
+			l.appendSpans([]Span{{Text: string(r), Style: frag.Style}})
+		}
+	}
+}
+
+func (l *lineBreaker) appendSpans(spans []Span) {
+	for _, s := range spans {
+		if s.Text == "" {
+			continue
+		}
+		if n := len(l.current); n > 0 && l.current[n-1].Style == s.Style {
+			l.current[n-1].Text += s.Text
+		} else {
+			l.current = append(l.current, s)
+		}
+		l.width += l.measure(s)
+	}
+}
+
+func (l *lineBreaker) spansWidth(spans []Span) int {
+	w := 0
+	for _, s := range spans {
+		w += l.measure(s)
+	}
+	return w
+}
+
+// Returns the width of spans in dots.
+func (e *Escpos) spansWidth(spans []Span) int {
+	w := 0
+	for _, s := range spans {
+		w += e.spanWidth(s)
+	}
+	return w
+}
+
+// Returns the width of a span in dots.
+func (e *Escpos) spanWidth(s Span) int {
+	return StringWidth(s.Text) * e.CellWidth(s.Style)
+}
+
+// Returns a copy of spans with the justification set to justify.
+func justifySpans(spans []Span, justify uint8) []Span {
+	out := make([]Span, len(spans))
+	for i, s := range spans {
+		s.Style.Justify = justify
+		out[i] = s
+	}
+	return out
+}
+
+// Returns the size multiplier for a Style.Width or Style.Height value, treating 0 as 1.
+func multiplier(p uint8) int {
+	if p == 0 {
+		return 1
+	}
+	return int(p)
+}
+
+// Reports whether r is an East Asian wide or fullwidth rune.
+func isWide(r rune) bool {
+	return r >= 0x1100 && (r <= 0x115f || // Hangul Jamo
+		r == 0x2329 || r == 0x232a ||
+		(r >= 0x2e80 && r <= 0x303e) || // CJK radicals, punctuation
+		(r >= 0x3041 && r <= 0x33ff) || // Hiragana, Katakana, CJK compatibility
+		(r >= 0x3400 && r <= 0x4dbf) || // CJK extension A
+		(r >= 0x4e00 && r <= 0x9fff) || // CJK unified ideographs
+		(r >= 0xa000 && r <= 0xa4cf) || // Yi
+		(r >= 0xac00 && r <= 0xd7a3) || // Hangul syllables
+		(r >= 0xf900 && r <= 0xfaff) || // CJK compatibility ideographs
+		(r >= 0xfe30 && r <= 0xfe4f) || // CJK compatibility forms
+		(r >= 0xff00 && r <= 0xff60) || // Fullwidth forms
+		(r >= 0xffe0 && r <= 0xffe6) ||
+		(r >= 0x1f300 && r <= 0x1f64f) || // Emoji
+		(r >= 0x1f900 && r <= 0x1f9ff) ||
+		(r >= 0x20000 && r <= 0x3fffd)) // CJK extensions B and later
+}
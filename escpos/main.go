@@ -6,6 +6,7 @@ import (
 	"image"
 	"io"
 	"math"
+	"strings"
 
 	"github.com/qiniu/iconv"
 )
@@ -18,12 +19,17 @@ type Style struct {
 	UpsideDown    bool
 	Rotate        bool
 	Justify       uint8
+	Font          uint8 // FontA, FontB or FontC
+	CharSpacing   uint8 // right-side character spacing in dots
 }
 
 const (
 	JustifyLeft                 uint8 = 0
 	JustifyCenter               uint8 = 1
 	JustifyRight                uint8 = 2
+	FontA                       uint8 = 0 // 12x24 dots
+	FontB                       uint8 = 1 // 9x17 or 9x24 dots, depending on the printer
+	FontC                       uint8 = 2 // not available on every printer
 	QRCodeErrorCorrectionLevelL uint8 = 48
 	QRCodeErrorCorrectionLevelM uint8 = 49
 	QRCodeErrorCorrectionLevelQ uint8 = 50
@@ -31,6 +37,8 @@ const (
 	esc                         byte  = 0x1B
 	gs                          byte  = 0x1D
 	fs                          byte  = 0x1C
+	ht                          byte  = 0x09
+	maxTabStops                       = 32
 )
 
 type PrinterConfig struct {
@@ -40,22 +48,42 @@ type PrinterConfig struct {
 	DisableRotate     bool
 	DisableUpsideDown bool
 	DisableJustify    bool
+	DotsPerLine       uint16 // printable width in dots, 0 means 576 (80mm paper at 203dpi)
+}
+
+// Layout holds the print position settings that were last sent to the printer.
+type Layout struct {
+	LeftMargin     uint16  // left margin in dots (GS L)
+	PrintAreaWidth uint16  // print area width in dots (GS W), 0 means the printer default
+	TabStops       []uint8 // horizontal tab positions in characters (ESC D), nil means every 8 characters
 }
 
 type Escpos struct {
-	dst    *bufio.Writer
-	Style  Style
-	config PrinterConfig
+	dst       *bufio.Writer
+	Style     Style
+	Layout    Layout
+	config    PrinterConfig
+	userRunes map[rune]byte
+	charset   string // iconv name of the selected code page, empty for no conversion
 }
 
 // New create an Escpos printer
 func New(dst io.Writer) (e *Escpos) {
 	e = &Escpos{
-		dst: bufio.NewWriter(dst),
+		dst:   bufio.NewWriter(dst),
+		Style: Style{Width: 1, Height: 1},
 	}
 	return
 }
 
+// Returns a printer with the same configuration and state as e that writes to dst.
+func (e *Escpos) derive(dst io.Writer) *Escpos {
+	d := *e
+	d.dst = bufio.NewWriter(dst)
+	d.Layout.TabStops = append([]uint8(nil), e.Layout.TabStops...)
+	return &d
+}
+
 // Sets the Printerconfig
 func (e *Escpos) SetConfig(conf PrinterConfig) {
 	e.config = conf
@@ -87,6 +115,11 @@ func (e *Escpos) WriteRaw(data []byte) (int, error) {
 
 // Writes a string using the predefined options.
 func (e *Escpos) Write(data string) (int, error) {
+	return e.writeStyled(e.encode(e.replaceUserRunes(data)))
+}
+
+// Writes already encoded text after applying the style.
+func (e *Escpos) writeStyled(data []byte) (int, error) {
 	// we gonna write sum text, so apply the styles!
 	var err error
 	// Bold
@@ -135,13 +168,25 @@ func (e *Escpos) Write(data string) (int, error) {
 		}
 	}
 
+	// Font
+	_, err = e.WriteRaw([]byte{esc, 'M', e.Style.Font})
+	if err != nil {
+		return 0, err
+	}
+
+	// Character spacing
+	_, err = e.WriteRaw([]byte{esc, ' ', e.Style.CharSpacing})
+	if err != nil {
+		return 0, err
+	}
+
 	// Width / Height
-	_, err = e.WriteRaw([]byte{gs, '!', ((e.Style.Width - 1) << 4) | (e.Style.Height - 1)})
+	_, err = e.WriteRaw([]byte{gs, '!', byte(multiplier(e.Style.Width)-1)<<4 | byte(multiplier(e.Style.Height)-1)})
 	if err != nil {
 		return 0, err
 	}
 
-	return e.WriteRaw([]byte(data))
+	return e.WriteRaw(data)
 }
 
 // WriteGBK writes a string to the printer using GBK encoding
@@ -152,7 +197,7 @@ func (e *Escpos) WriteGBK(data string) (int, error) {
 	}
 	defer cd.Close()
 	gbk := cd.ConvString(data)
-	return e.Write(gbk)
+	return e.writeStyled([]byte(gbk))
 }
 
 // WriteWEU writes a string to the printer using Western European encoding
@@ -163,7 +208,7 @@ func (e *Escpos) WriteWEU(data string) (int, error) {
 	}
 	defer cd.Close()
 	weu := cd.ConvString(data)
-	return e.Write(weu)
+	return e.writeStyled([]byte(weu))
 }
 
 // Sets the printer to print Bold text.
@@ -205,6 +250,21 @@ func (e *Escpos) UpsideDown(p bool) *Escpos {
 	return e
 }
 
+// Selects the character font. Use FontA, FontB or FontC.
+func (e *Escpos) Font(p uint8) *Escpos {
+	if p > FontC {
+		p = FontA
+	}
+	e.Style.Font = p
+	return e
+}
+
+// Sets the spacing to the right of each character in dots.
+func (e *Escpos) CharacterSpacing(p uint8) *Escpos {
+	e.Style.CharSpacing = p
+	return e
+}
+
 // Sets the size of the font. Width and Height should be between 0 and 5. If the value is bigger than 5, 5 is used.
 func (e *Escpos) Size(width uint8, height uint8) *Escpos {
 	// Values > 5 are not supported by esc/pos, so we'll set 5 as the maximum.
@@ -253,7 +313,7 @@ func (e *Escpos) BarcodeWidth(p uint8) (int, error) {
 	if p > 6 {
 		p = 6
 	}
-	return e.WriteRaw([]byte{gs, 'h', p})
+	return e.WriteRaw([]byte{gs, 'w', p})
 }
 
 // Prints a UPCA Barcode. code can only be numerical characters and must have a length of 11 or 12
@@ -304,8 +364,35 @@ func (e *Escpos) EAN8(code string) (int, error) {
 	return e.WriteRaw(append([]byte{gs, 'k', 3}, byteCode...))
 }
 
+// Prints a CODE39 Barcode. code can contain digits, upper case letters, space and $ % * + - . /
+func (e *Escpos) Code39(code string) (int, error) {
+	if len(code) < 1 || len(code) > 255 {
+		return 0, fmt.Errorf("code should have a length between 1 and 255")
+	}
+	for _, c := range code {
+		if !(c >= '0' && c <= '9') && !(c >= 'A' && c <= 'Z') && !strings.ContainsRune(" $%*+-./", c) {
+			return 0, fmt.Errorf("code contains a character not supported by CODE39: %q", c)
+		}
+	}
+	return e.WriteRaw(append([]byte{gs, 'k', 69, byte(len(code))}, []byte(code)...))
+}
+
+// Prints a CODE128 Barcode using code set B. code can contain ASCII characters from 32 to 126
+func (e *Escpos) Code128(code string) (int, error) {
+	if len(code) < 1 || len(code) > 253 {
+		return 0, fmt.Errorf("code should have a length between 1 and 253")
+	}
+	for _, c := range code {
+		if c < 32 || c > 126 {
+			return 0, fmt.Errorf("code contains a character not supported by CODE128: %q", c)
+		}
+	}
+	data := append([]byte{'{', 'B'}, []byte(code)...)
+	return e.WriteRaw(append([]byte{gs, 'k', 73, byte(len(data))}, data...))
+}
+
 // TODO:
-// CODE39, ITF, CODABAR
+// ITF, CODABAR
 
 // Prints a QR Code.
 // code specifies the data to be printed
@@ -345,7 +432,7 @@ func (e *Escpos) QRCode(code string, model bool, size uint8, correctionLevel uin
 	if correctionLevel > 51 {
 		correctionLevel = 51
 	}
-	_, err = e.WriteRaw([]byte{gs, '(', 'k', 3, 0, 49, 69, size})
+	_, err = e.WriteRaw([]byte{gs, '(', 'k', 3, 0, 49, 69, correctionLevel})
 	if err != nil {
 		return 0, err
 	}
@@ -424,6 +511,8 @@ func (e *Escpos) LineSpacing(p uint8) (int, error) {
 
 // Initializes the printer to the settings it had when turned on
 func (e *Escpos) Initialize() (int, error) {
+	e.Layout = Layout{}
+	e.charset = ""
 	return e.WriteRaw([]byte{esc, '@'})
 }
 
@@ -432,11 +521,83 @@ func (e *Escpos) MotionUnits(x, y uint8) (int, error) {
 	return e.WriteRaw([]byte{gs, 'P', x, y})
 }
 
+// Print position stuff.
+
+// Sets the left margin in dots. The margin applies from the beginning of the next line.
+func (e *Escpos) LeftMargin(dots uint16) (int, error) {
+	e.Layout.LeftMargin = dots
+	return e.WriteRaw([]byte{gs, 'L', byte(dots & 0xff), byte(dots >> 8)})
+}
+
+// Sets the width of the print area in dots, measured from the left margin. 0 resets it to the rest of the line.
+func (e *Escpos) PrintAreaWidth(dots uint16) (int, error) {
+	if dots == 0 && e.Layout.LeftMargin < e.DotsPerLine() {
+		dots = e.DotsPerLine() - e.Layout.LeftMargin
+	}
+	e.Layout.PrintAreaWidth = dots
+	return e.WriteRaw([]byte{gs, 'W', byte(dots & 0xff), byte(dots >> 8)})
+}
+
+// Sets the horizontal tab positions in characters from the start of the line.
+// Positions must be ascending and at most 32 can be set. Calling it without
+// positions clears all tab stops.
+func (e *Escpos) TabPositions(columns ...uint8) (int, error) {
+	cmd, err := tabPositionsCommand(columns)
+	if err != nil {
+		return 0, err
+	}
+	e.Layout.TabStops = append([]uint8{}, columns...)
+	return e.WriteRaw(cmd)
+}
+
+// Restores the power-on tab positions of every 8 characters.
+func (e *Escpos) DefaultTabPositions() (int, error) {
+	n, err := e.TabPositions(defaultTabStops()...)
+	e.Layout.TabStops = nil
+	return n, err
+}
+
+// Moves the print position to the next tab stop.
+func (e *Escpos) HorizontalTab() (int, error) {
+	return e.WriteRaw([]byte{ht})
+}
+
+// Moves the print position to dots from the start of the line (left margin).
+func (e *Escpos) AbsolutePosition(dots uint16) (int, error) {
+	return e.WriteRaw([]byte{esc, '$', byte(dots & 0xff), byte(dots >> 8)})
+}
+
+// Returns the printable width of the configured printer in dots.
+func (e *Escpos) DotsPerLine() uint16 {
+	if e.config.DotsPerLine == 0 {
+		return 576
+	}
+	return e.config.DotsPerLine
+}
+
+// Returns the tab positions currently in effect on the printer.
+func (e *Escpos) TabStops() []uint8 {
+	if e.Layout.TabStops == nil {
+		return defaultTabStops()
+	}
+	return e.Layout.TabStops
+}
+
 // Feeds the paper to the end and performs a Cut. In the ESC/POS Command Manual there is also PartialCut and FullCut documented, but it does exactly the same.
 func (e *Escpos) Cut() (int, error) {
 	return e.WriteRaw([]byte{gs, 'V', 'A', 0x00})
 }
 
+// Sends a pulse to the cash drawer kick-out connector. pin can be 2 or 5.
+func (e *Escpos) OpenDrawer(pin uint8) (int, error) {
+	var m byte
+	if pin == 5 {
+		m = 1
+	}
+	// on for 100ms, off for 500ms (in units of 2ms)
+	return e.WriteRaw([]byte{esc, 'p', m, 50, 250})
+}
+
 // Helpers
 func boolToByte(b bool) byte {
 	if b {
@@ -444,6 +605,29 @@ func boolToByte(b bool) byte {
 	}
 	return 0x00
 }
+func tabPositionsCommand(columns []uint8) ([]byte, error) {
+	if len(columns) > maxTabStops {
+		return nil, fmt.Errorf("at most %d tab positions can be set", maxTabStops)
+	}
+	cmd := []byte{esc, 'D'}
+	for i, c := range columns {
+		if c == 0 {
+			return nil, fmt.Errorf("tab positions start at 1")
+		}
+		if i > 0 && c <= columns[i-1] {
+			return nil, fmt.Errorf("tab positions must be in ascending order")
+		}
+		cmd = append(cmd, c)
+	}
+	return append(cmd, 0), nil
+}
+func defaultTabStops() []uint8 {
+	stops := make([]uint8, 0, maxTabStops)
+	for c := 8; c < 256 && len(stops) < maxTabStops; c += 8 {
+		stops = append(stops, uint8(c))
+	}
+	return stops
+}
 func onlyDigits(s string) bool {
 	for _, c := range s {
 		if c < '0' || c > '9' {
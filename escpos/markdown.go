@@ -3,65 +3,274 @@ package escpos
 import (
 	"bytes"
 	"fmt"
-	"math"
+	"io"
+	"strings"
 
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/extension"
 	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/renderer"
 	"github.com/yuin/goldmark/util"
 )
 
 const (
+	// Deprecated: the number of characters per line depends on the printer and style, use Escpos.Columns.
 	CharacterWidth uint8 = 48
 )
 
+// LinkStyle defines how the destination of a markdown link is printed.
+type LinkStyle uint8
+
+const (
+	LinkURLInline LinkStyle = iota // print the URL in brackets after the link text
+	LinkFootnote                   // number the link and print the URLs at the end of the document
+	LinkTextOnly                   // print only the link text
+)
+
+type markdownOptions struct {
+	linkStyle     LinkStyle
+	linkQRCodes   bool
+	imageDir      string
+	allowDataURI  bool
+	tableBorder   TableBorder
+	tableTruncate bool
+	headingFont   *TrueTypeFont
+}
+
+// MarkdownOption configures how markdown is rendered.
+type MarkdownOption func(*markdownOptions)
+
+// Sets how link destinations are printed. The default is LinkURLInline.
+func WithLinkStyle(s LinkStyle) MarkdownOption {
+	return func(o *markdownOptions) {
+		o.linkStyle = s
+	}
+}
+
+// Prints a QR code for every link under the paragraph that contains it,
+// instead of printing the URL as text.
+func WithLinkQRCodes(enabled bool) MarkdownOption {
+	return func(o *markdownOptions) {
+		o.linkQRCodes = enabled
+	}
+}
+
+// Sets the directory relative image paths are loaded from. By default they
+// are relative to the working directory.
+func WithImageDir(dir string) MarkdownOption {
+	return func(o *markdownOptions) {
+		o.imageDir = dir
+	}
+}
+
+// Allows images embedded as data: URIs.
+func WithDataURIImages(enabled bool) MarkdownOption {
+	return func(o *markdownOptions) {
+		o.allowDataURI = enabled
+	}
+}
+
+// Sets the border tables are drawn with. The default is BorderNone.
+func WithTableBorder(b TableBorder) MarkdownOption {
+	return func(o *markdownOptions) {
+		o.tableBorder = b
+	}
+}
+
+// Truncates table cells that don't fit their column instead of wrapping them.
+func WithTableTruncate(enabled bool) MarkdownOption {
+	return func(o *markdownOptions) {
+		o.tableTruncate = enabled
+	}
+}
+
+// Prints headings rasterized with a TrueType font instead of the printer
+// font, at the height the printer font would have. Body text is not affected.
+func WithHeadingFont(f *TrueTypeFont) MarkdownOption {
+	return func(o *markdownOptions) {
+		o.headingFont = f
+	}
+}
+
+// Module size in dots of the QR codes printed for links.
+const linkQRCodeSize uint8 = 4
+
+// Dots per em of text printed with a heading font at size 1, the height of Font A.
+const headingDotsPerEm = 24
+
+// Bullets used for unordered list items, by nesting depth.
+var listBullets = []string{"-", "*", "+"}
+
+// indentEntry is the indentation a container block adds to the lines inside it.
+type indentEntry struct {
+	indent []Span
+	// marker is printed instead of indent on the first line of a list item.
+	marker []Span
+}
+
 type escr struct {
+	p    *Escpos
+	opts markdownOptions
+
+	base      Style
+	styles    []Style // inline style stack, the last entry is the current style
+	spans     []Span  // inline content of the current block
+	indents   []indentEntry
+	footnotes []string
+	qrCodes   []string // link URLs to print under the current block
+	table     *Table   // table being collected
+	row       []TableCell
+
+	lineOffset int // lines before the source, like front matter
+}
+
+// MarkdownError is returned by WriteMarkdown when a node can't be printed.
+type MarkdownError struct {
+	Kind ast.NodeKind // kind of the node that failed
+	Line int          // line of the node in the document, 0 if unknown
+	Err  error
+}
+
+func (e *MarkdownError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("failed to print markdown %s: %v", e.Kind, e.Err)
+	}
+	return fmt.Sprintf("failed to print markdown %s on line %d: %v", e.Kind, e.Line, e.Err)
+}
+
+func (e *MarkdownError) Unwrap() error {
+	return e.Err
+}
+
+// Returns the line of the source a node starts on, or 0 if it has no position.
+// Nodes without a position of their own take the position of their first
+// descendant or else their parent.
+func sourceLine(n ast.Node, source []byte) int {
+	offset := -1
+	ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		switch {
+		case !entering:
+		case c.Type() == ast.TypeBlock && c.Lines().Len() > 0:
+			offset = c.Lines().At(0).Start
+		case c.Kind() == ast.KindText:
+			offset = c.(*ast.Text).Segment.Start
+		default:
+			return ast.WalkContinue, nil
+		}
+		if offset >= 0 {
+			return ast.WalkStop, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	if offset < 0 {
+		if n.Parent() != nil {
+			return sourceLine(n.Parent(), source)
+		}
+		return 0
+	}
+	return bytes.Count(source[:min(offset, len(source))], []byte{'\n'}) + 1
+}
+
+// NewMarkdownRenderer returns a goldmark node renderer that prints to p. The
+// renderer ignores the writer passed by goldmark, call p.Print to send the output.
+func NewMarkdownRenderer(p *Escpos, opts ...MarkdownOption) renderer.NodeRenderer {
+	var o markdownOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return newMarkdownRenderer(p, o)
+}
+
+func newMarkdownRenderer(p *Escpos, opts markdownOptions) *escr {
+	return &escr{p: p, opts: opts, base: p.Style, styles: []Style{p.Style}}
 }
 
 func (r *escr) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	register := func(kind ast.NodeKind, fn renderer.NodeRendererFunc) {
+		reg.Register(kind, r.withContext(fn))
+	}
+
 	// blocks
 
-	reg.Register(ast.KindDocument, r.renderDocument)
-	reg.Register(ast.KindHeading, r.renderHeading)
-	// reg.Register(ast.KindBlockquote, r.renderBlockquote)
-	// reg.Register(ast.KindCodeBlock, r.renderCodeBlock)
-	// reg.Register(ast.KindFencedCodeBlock, r.renderFencedCodeBlock)
-	// reg.Register(ast.KindHTMLBlock, r.renderHTMLBlock)
-	// reg.Register(ast.KindList, r.renderList)
-	reg.Register(ast.KindListItem, r.renderListItem)
-	reg.Register(ast.KindParagraph, r.renderParagraph)
-	// reg.Register(ast.KindTextBlock, r.renderTextBlock)
-	// reg.Register(ast.KindThematicBreak, r.renderThematicBreak)
-	reg.Register(extast.KindTable, r.renderTable)
-	reg.Register(extast.KindTableHeader, r.renderTableHeader)
-	reg.Register(extast.KindTableRow, r.renderTableRow)
-	reg.Register(extast.KindTableCell, r.renderTableCell)
+	register(ast.KindDocument, r.renderDocument)
+	register(ast.KindHeading, r.withAttributes(r.renderHeading))
+	register(ast.KindBlockquote, r.withAttributes(r.renderBlockquote))
+	register(ast.KindCodeBlock, r.withAttributes(r.renderCodeBlock))
+	register(ast.KindFencedCodeBlock, r.withAttributes(r.renderCodeBlock))
+	register(ast.KindHTMLBlock, r.renderHTML)
+	register(ast.KindList, r.withAttributes(r.renderList))
+	register(ast.KindListItem, r.renderListItem)
+	register(ast.KindParagraph, r.withAttributes(r.renderParagraph))
+	register(ast.KindTextBlock, r.renderParagraph)
+	register(ast.KindThematicBreak, r.withAttributes(r.renderThematicBreak))
+	register(extast.KindTable, r.withAttributes(r.renderTable))
+	register(extast.KindTableHeader, r.renderTableRow)
+	register(extast.KindTableRow, r.renderTableRow)
+	register(extast.KindTableCell, r.renderTableCell)
+	register(KindDirective, r.withAttributes(r.renderDirective))
 	// inlines
-	// reg.Register(ast.KindAutoLink, r.renderAutoLink)
-	// reg.Register(ast.KindCodeSpan, r.renderCodeSpan)
-	// reg.Register(ast.KindEmphasis, r.renderEmphasis)
-	// reg.Register(ast.KindImage, r.renderImage)
-	// reg.Register(ast.KindLink, r.renderLink)
-	// reg.Register(ast.KindRawHTML, r.renderRawHTML)
-	reg.Register(ast.KindText, r.renderText)
-	reg.Register(ast.KindString, r.renderString)
+	register(ast.KindAutoLink, r.renderAutoLink)
+	register(ast.KindCodeSpan, r.renderCodeSpan)
+	register(ast.KindEmphasis, r.renderEmphasis)
+	register(ast.KindImage, r.renderImage)
+	register(ast.KindLink, r.renderLink)
+	register(ast.KindRawHTML, r.renderHTML)
+	register(ast.KindText, r.renderText)
+	register(ast.KindString, r.renderString)
+}
+
+// Wraps a renderer so its errors are returned as a MarkdownError that tells
+// which node failed.
+func (r *escr) withContext(fn renderer.NodeRendererFunc) renderer.NodeRendererFunc {
+	return func(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+		status, err := fn(writer, source, node, entering)
+		if err != nil {
+			if _, ok := err.(*MarkdownError); !ok {
+				err = &MarkdownError{Kind: node.Kind(), Line: r.lineOffset + sourceLine(node, source), Err: err}
+			}
+		}
+		return status, err
+	}
+}
+
+// Wraps a block renderer so the block's attributes, like {: .center}, apply
+// to the style of everything inside it.
+func (r *escr) withAttributes(fn renderer.NodeRendererFunc) renderer.NodeRendererFunc {
+	return func(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if node.Attributes() == nil {
+			return fn(writer, source, node, entering)
+		}
+		if entering {
+			r.pushStyle(styleFromAttributes(r.style(), node))
+			return fn(writer, source, node, entering)
+		}
+		status, err := fn(writer, source, node, entering)
+		r.popStyle()
+		return status, err
+	}
 }
 
 func (r *escr) renderDocument(writer util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
 	if !entering {
 		// Don't cut at the end for now...
 		// writer.Write([]byte{gs, 'V', 'A', 0x00})
-		return ast.WalkContinue, nil
+		return ast.WalkContinue, r.writeFootnotes()
 	}
 	return ast.WalkContinue, nil
 }
 
 func (r *escr) renderText(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
-	if entering {
-		n := node.(*ast.Text)
-		writer.Write(n.Segment.Value(source))
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.Text)
+	r.appendText(string(n.Segment.Value(source)))
+	if n.HardLineBreak() {
+		r.appendText("\n")
+	} else if n.SoftLineBreak() {
+		r.appendText(" ")
 	}
 	return ast.WalkContinue, nil
 }
@@ -71,193 +280,507 @@ func (r *escr) renderString(writer util.BufWriter, source []byte, node ast.Node,
 		return ast.WalkContinue, nil
 	}
 	n := node.(*ast.String)
-	writer.Write(n.Value)
+	r.appendText(string(n.Value))
 
 	return ast.WalkContinue, nil
 }
 
 func (r *escr) renderHeading(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
 	n := node.(*ast.Heading)
-	level := uint8(6 - n.Level)
 
 	if entering {
-		writer.Write([]byte{gs, '!', (level << 4) | (level)})
-	} else {
-		writer.Write([]byte{gs, '!', (0 << 4) | (0)})
-		writer.Write([]byte{0x0A})
+		style := r.style()
+		if _, ok := n.AttributeString("size"); !ok {
+			style.Width = uint8(7 - n.Level)
+			style.Height = style.Width
+		}
+		r.pushStyle(style)
+		return ast.WalkContinue, nil
 	}
-
-	return ast.WalkContinue, nil
+	height := r.style().Height
+	r.popStyle()
+	return ast.WalkContinue, r.flushHeading(height)
 }
 
-// func (r *escr) renderParagraph(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
-// 	if !entering {
-// 		writer.Write([]byte{0x0A}) // Move to next line after paragraph ends
-// 	}
-// 	return ast.WalkContinue, nil
-// }
+// Writes the collected heading text, with the heading font if there is one.
+func (r *escr) flushHeading(height uint8) error {
+	f := r.opts.headingFont
+	if f == nil {
+		return r.flush()
+	}
+	size := float64(headingDotsPerEm * multiplier(height))
+	err := r.flushTextWith(func(spans []Span, opts WrapOptions) (int, error) {
+		return r.p.WriteTrueTypeSpans(spans, opts, f, size)
+	})
+	if err != nil {
+		return err
+	}
+	return r.writeQRCodes()
+}
 
 func (r *escr) renderParagraph(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
-	// Check if the paragraph has the ".center" attribute
-	isCentered := false
-	if n, ok := node.(*ast.Paragraph); ok {
-		if n.Attributes() != nil {
-			fmt.Println(n.Attributes())
-			for _, attr := range n.Attributes() {
-				if string(attr.Name) == "class" && string(attr.Value.([]byte)) == "center" {
-					isCentered = true
-				}
-			}
-		}
+	if entering {
+		return ast.WalkContinue, nil
 	}
+	return ast.WalkContinue, r.flush()
+}
 
-	if entering && isCentered {
-		writer.Write([]byte{0x1B, 0x61, 0x01})
-	} else if !entering && isCentered {
-		writer.Write([]byte{0x0A, 0x1B, 0x61, 0x00})
-	} else if !entering {
-		// Standard non-centered paragraph
-		writer.Write([]byte{0x0A})
+func (r *escr) renderBlockquote(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		r.indents = append(r.indents, indentEntry{indent: []Span{{Text: "| ", Style: r.style()}}})
+	} else {
+		r.indents = r.indents[:len(r.indents)-1]
 	}
 	return ast.WalkContinue, nil
 }
 
-func (r *escr) renderListItem(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+func (r *escr) renderCodeBlock(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
 	if !entering {
-		writer.Write([]byte{0x0A}) // Each list item gets its own line
+		return ast.WalkContinue, nil
 	}
+	style := r.style()
+	style.Font = FontB
+	var code strings.Builder
+	lines := node.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		code.Write(line.Value(source))
+	}
+	r.spans = append(r.spans, Span{Text: strings.TrimRight(code.String(), "\n"), Style: style})
+	return ast.WalkSkipChildren, r.flush()
+}
+
+// Raw HTML can't be printed, so it is left out.
+func (r *escr) renderHTML(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *escr) renderList(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
 	return ast.WalkContinue, nil
 }
 
-func (r *escr) renderTable(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+func (r *escr) renderListItem(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		// An empty item still gets its marker
+		if r.indents[len(r.indents)-1].marker != nil {
+			if err := r.flush(); err != nil {
+				return ast.WalkStop, err
+			}
+		}
+		r.indents = r.indents[:len(r.indents)-1]
+		return ast.WalkContinue, nil
+	}
+
+	marker := listMarker(node) + " "
+	r.indents = append(r.indents, indentEntry{
+		indent: []Span{{Text: strings.Repeat(" ", StringWidth(marker)), Style: r.style()}},
+		marker: []Span{{Text: marker, Style: r.style()}},
+	})
 	return ast.WalkContinue, nil
 }
-func (r *escr) renderTableHeader(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+
+// Returns "1." style markers for ordered lists and a bullet depending on the
+// nesting depth for unordered lists.
+func listMarker(item ast.Node) string {
+	list := item.Parent().(*ast.List)
+	if list.IsOrdered() {
+		index := list.Start
+		for c := list.FirstChild(); c != nil && c != item; c = c.NextSibling() {
+			index++
+		}
+		return fmt.Sprintf("%d%c", index, list.Marker)
+	}
+	depth := 0
+	for p := list.Parent(); p != nil; p = p.Parent() {
+		if p.Kind() == ast.KindList {
+			depth++
+		}
+	}
+	return listBullets[depth%len(listBullets)]
+}
+
+func (r *escr) renderThematicBreak(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	return ast.WalkContinue, r.writeRule()
+}
+
+// Prints a line of dashes across the print area.
+func (r *escr) writeRule() error {
+	opts := r.wrapOptions()
+	width := r.p.LineWidth() - r.p.spansWidth(opts.Indent) - r.p.spansWidth(opts.Prefix)
+	cell := r.p.CellWidth(r.style())
+	if width < cell {
+		return nil
+	}
+	r.spans = append(r.spans, Span{Text: strings.Repeat("-", width/cell), Style: r.style()})
+	return r.flush()
+}
+
+func (r *escr) renderEmphasis(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
 	if !entering {
-		writer.Write([]byte{'\n'})
+		r.popStyle()
 		return ast.WalkContinue, nil
 	}
+	n := node.(*ast.Emphasis)
+	style := r.style()
+	if n.Level >= 2 {
+		style.Bold = true
+	} else {
+		style.Underline = 1
+	}
+	r.pushStyle(style)
 	return ast.WalkContinue, nil
 }
-func (r *escr) renderTableRow(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+
+func (r *escr) renderCodeSpan(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
 	if !entering {
-		writer.Write([]byte{'\n'})
+		r.popStyle()
 		return ast.WalkContinue, nil
 	}
+	style := r.style()
+	style.Font = FontB
+	r.pushStyle(style)
+	return ast.WalkContinue, nil
+}
 
+func (r *escr) renderLink(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.Link)
+	r.appendLink(string(n.Destination), string(nodeText(n, source)))
 	return ast.WalkContinue, nil
 }
 
-// Skips children as it only processes text
-func (r *escr) renderTableCell(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+// Adds the destination of a link after its text, as set by the link options.
+func (r *escr) appendLink(url, text string) {
+	if url == "" || url == text {
+		return
+	}
+	if r.opts.linkQRCodes {
+		r.qrCodes = append(r.qrCodes, url)
+		return
+	}
+	small := r.style()
+	small.Font = FontB
+	switch r.opts.linkStyle {
+	case LinkURLInline:
+		r.spans = append(r.spans, Span{Text: " (" + url + ")", Style: small})
+	case LinkFootnote:
+		r.footnotes = append(r.footnotes, url)
+		r.spans = append(r.spans, Span{Text: fmt.Sprintf("[%d]", len(r.footnotes)), Style: small})
+	}
+}
+
+// Prints the image on a line of its own. If the image can't be loaded, or is
+// wider than the print area, its alt text is printed instead, and so it is in
+// tables, which are printed after all their cells are collected.
+func (r *escr) renderImage(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering || r.table != nil {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.Image)
+	printed, err := r.printImage(string(n.Destination))
+	if err != nil {
+		return ast.WalkStop, err
+	}
+	if !printed {
+		return ast.WalkContinue, nil
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+// Prints the image at src on a line of its own, scaled to the paper width.
+// It reports false if the image can't be loaded.
+func (r *escr) printImage(src string) (bool, error) {
+	img, err := loadImage(src, r.opts.imageDir, r.opts.allowDataURI)
+	if err != nil {
+		return false, nil
+	}
+	// Finish the text before the image
+	if err := r.flushText(); err != nil {
+		return false, err
+	}
+	if _, err := r.p.WriteRaw([]byte{esc, 'a', r.style().Justify}); err != nil {
+		return false, err
+	}
+	if _, err := r.p.PrintImageFit(img); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *escr) renderAutoLink(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
 	if !entering {
-		return ast.WalkSkipChildren, nil
+		return ast.WalkContinue, nil
 	}
-	if node.ChildCount() != 1 {
-		fmt.Println("Invalid table cell. Too many children")
-		fmt.Println(node.ChildCount())
-		return ast.WalkSkipChildren, nil
+	n := node.(*ast.AutoLink)
+	r.appendText(string(n.Label(source)))
+	return ast.WalkSkipChildren, nil
+}
+
+// Collects the cells of a table and prints it with WriteTable when leaving it.
+func (r *escr) renderTable(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		r.table = &Table{Border: r.opts.tableBorder, Truncate: r.opts.tableTruncate}
+		for _, a := range node.(*extast.Table).Alignments {
+			switch a {
+			case extast.AlignCenter:
+				r.table.Align = append(r.table.Align, JustifyCenter)
+			case extast.AlignRight:
+				r.table.Align = append(r.table.Align, JustifyRight)
+			default:
+				r.table.Align = append(r.table.Align, JustifyLeft)
+			}
+		}
+		return ast.WalkContinue, nil
 	}
-	n := node.FirstChild().(*ast.Text)
-	segment := n.Segment
 
-	// Write the cell content
-	var cellContent []byte
-	if n.IsRaw() {
-		cellContent = segment.Value(source)
+	t := r.table
+	r.table = nil
+	saved := r.p.Style
+	r.p.Style = r.style()
+	_, err := r.p.WriteTable(*t)
+	r.p.Style = saved
+	return ast.WalkContinue, err
+}
+
+func (r *escr) renderTableRow(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		r.row = nil
+		return ast.WalkContinue, nil
+	}
+	if node.Kind() == extast.KindTableHeader {
+		r.table.Header = r.row
 	} else {
-		cellContent = segment.Value(source)
+		r.table.Rows = append(r.table.Rows, r.row)
 	}
-	writer.Write(cellContent)
+	return ast.WalkContinue, nil
+}
 
-	// Calculate column widths and current column index
-	tableNode := node.Parent().Parent() // Get the table node
-	var columnWidths []int
-	var currentCol int
+func (r *escr) renderTableCell(writer util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		r.row = append(r.row, r.spans)
+		r.spans = nil
+	}
+	return ast.WalkContinue, nil
+}
 
-	// First pass: calculate maximum width for each column
-	ast.Walk(tableNode, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+// Returns the concatenated text of all Text and String nodes below n.
+func nodeText(n ast.Node, source []byte) []byte {
+	var buf bytes.Buffer
+	ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
 		if !entering {
 			return ast.WalkContinue, nil
 		}
-		if n.Kind() == extast.KindTableRow {
-			colIndex := 0
-			for child := n.FirstChild(); child != nil; child = child.NextSibling() {
-				if child.Kind() == extast.KindTableCell {
-					if child.FirstChild() != nil && child.FirstChild().Kind() == ast.KindText {
-						cellText := child.FirstChild().(*ast.Text)
-						cellLen := len(cellText.Segment.Value(source))
-
-						// Extend columnWidths slice if necessary
-						for len(columnWidths) <= colIndex {
-							columnWidths = append(columnWidths, 0)
-						}
-
-						if cellLen > columnWidths[colIndex] {
-							columnWidths[colIndex] = cellLen
-						}
-					}
-					colIndex++
-				}
-			}
+		switch c := c.(type) {
+		case *ast.Text:
+			buf.Write(c.Segment.Value(source))
+		case *ast.String:
+			buf.Write(c.Value)
 		}
 		return ast.WalkContinue, nil
 	})
+	return buf.Bytes()
+}
 
-	// Find current column index
-	currentCol = 0
-	for child := node.Parent().FirstChild(); child != nil && child != node; child = child.NextSibling() {
-		if child.Kind() == extast.KindTableCell {
-			currentCol++
-		}
-	}
-
-	// Calculate tabs needed for this cell
-	if currentCol < len(columnWidths) {
-		currentCellLen := len(cellContent)
-		maxColWidth := columnWidths[currentCol]
+func (r *escr) style() Style {
+	return r.styles[len(r.styles)-1]
+}
 
-		// Calculate which tab stop this column should end at
-		// Tab stops are at positions 8, 16, 24, 32, etc.
-		tabStop := int(math.Ceil(float64(maxColWidth+1)/8.0)) * 8
+func (r *escr) pushStyle(s Style) {
+	r.styles = append(r.styles, s)
+}
 
-		// Calculate how many characters we need to reach that tab stop
-		charsToNextTab := tabStop - currentCellLen
+func (r *escr) popStyle() {
+	if len(r.styles) > 1 {
+		r.styles = r.styles[:len(r.styles)-1]
+	}
+}
 
-		// Convert to number of tabs needed
-		tabsNeeded := int(math.Ceil(float64(charsToNextTab) / 8.0))
+func (r *escr) appendText(text string) {
+	style := r.style()
+	if n := len(r.spans); n > 0 && r.spans[n-1].Style == style {
+		r.spans[n-1].Text += text
+		return
+	}
+	r.spans = append(r.spans, Span{Text: text, Style: style})
+}
 
-		// Ensure at least 1 tab for column separation
-		if tabsNeeded < 1 {
-			tabsNeeded = 1
+// Builds the indentation for the next block from the enclosing containers.
+// List markers that have not been printed yet go in the prefix so following
+// lines hang under the item text.
+func (r *escr) wrapOptions() WrapOptions {
+	var opts WrapOptions
+	inPrefix := false
+	for _, entry := range r.indents {
+		if entry.marker != nil {
+			inPrefix = true
+		}
+		switch {
+		case !inPrefix:
+			opts.Indent = append(opts.Indent, entry.indent...)
+		case entry.marker != nil:
+			opts.Prefix = append(opts.Prefix, entry.marker...)
+		default:
+			opts.Prefix = append(opts.Prefix, entry.indent...)
 		}
+	}
+	return opts
+}
 
-		// Write the tabs
-		for i := 0; i < tabsNeeded; i++ {
-			writer.Write([]byte{0x09})
+// Writes the collected inline content as a wrapped block, followed by the QR
+// codes of the links it contained.
+func (r *escr) flush() error {
+	if err := r.flushText(); err != nil {
+		return err
+	}
+	return r.writeQRCodes()
+}
+
+// Prints the QR codes of the links collected since the last call.
+func (r *escr) writeQRCodes() error {
+	urls := r.qrCodes
+	r.qrCodes = nil
+	for _, url := range urls {
+		if _, err := r.p.WriteRaw([]byte{esc, 'a', r.style().Justify}); err != nil {
+			return err
+		}
+		if _, err := r.p.QRCode(url, true, linkQRCodeSize, QRCodeErrorCorrectionLevelM); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	return ast.WalkSkipChildren, nil
+// Writes the collected inline content as a wrapped block.
+func (r *escr) flushText() error {
+	return r.flushTextWith(r.p.WriteSpans)
 }
 
-var EscposNodeRenderer renderer.NodeRenderer = &escr{}
+// Writes the collected inline content with write.
+func (r *escr) flushTextWith(write func([]Span, WrapOptions) (int, error)) error {
+	opts := r.wrapOptions()
+	for i := range r.indents {
+		r.indents[i].marker = nil
+	}
+	spans := r.spans
+	r.spans = nil
+	if len(spans) == 0 && len(opts.Prefix) == 0 {
+		return nil
+	}
+	// Indentation follows the justification of the text
+	if len(spans) > 0 {
+		opts.Indent = justifySpans(opts.Indent, spans[0].Style.Justify)
+		opts.Prefix = justifySpans(opts.Prefix, spans[0].Style.Justify)
+	}
+	_, err := write(spans, opts)
+	return err
+}
 
-func (e *Escpos) WriteMarkdown(markdown []byte) (int, error) {
+func (r *escr) writeFootnotes() error {
+	small := r.base
+	small.Font = FontB
+	for i, url := range r.footnotes {
+		spans := []Span{{Text: url, Style: small}}
+		opts := WrapOptions{Prefix: []Span{{Text: fmt.Sprintf("[%d] ", i+1), Style: small}}}
+		if _, err := r.p.WriteSpans(spans, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteMarkdown renders markdown and writes it to the printer. Print options
+// in the front matter of the document, see FrontMatter, apply to this
+// document only. Nothing is written if the document can't be rendered, and
+// errors of single nodes are returned as a *MarkdownError. The returned count
+// is the number of bytes written to the printer.
+func (e *Escpos) WriteMarkdown(markdown []byte, opts ...MarkdownOption) (int, error) {
+	var o markdownOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	fm, body, err := ParseFrontMatter(markdown)
+	if err != nil {
+		return 0, err
+	}
+	frontMatterLines := bytes.Count(markdown[:len(markdown)-len(body)], []byte{'\n'})
+	// The footer and goldmark append to body, which shares its array with
+	// the document of the caller
+	body = bytes.Clone(body)
+
+	var buf bytes.Buffer
+	p := e.derive(&buf)
+	copies := 1
+	if fm != nil {
+		if err := p.applyFrontMatter(fm); err != nil {
+			return 0, err
+		}
+		if fm.Logo != "" {
+			if err := p.printLogo(fm.Logo, o); err != nil {
+				return 0, fmt.Errorf("failed to print logo: %w", err)
+			}
+		}
+		if fm.Footer != "" {
+			body = append(append(body, "\n\n"...), fm.Footer...)
+		}
+		if fm.Copies > 0 {
+			copies = fm.Copies
+		}
+	}
+
+	r := newMarkdownRenderer(p, o)
+	r.lineOffset = frontMatterLines
 	md := goldmark.New(
 		goldmark.WithExtensions(extension.Table),
+		goldmark.WithParserOptions(
+			parser.WithAttribute(),
+			parser.WithBlockParsers(util.Prioritized(&directiveParser{}, 50)),
+			parser.WithParagraphTransformers(util.Prioritized(&attributeTransformer{}, 50)),
+		),
 		goldmark.WithRenderer(
-			renderer.NewRenderer(renderer.WithNodeRenderers(util.Prioritized(EscposNodeRenderer, 1))),
+			renderer.NewRenderer(renderer.WithNodeRenderers(util.Prioritized(r, 1))),
 		),
 	)
-	var buf bytes.Buffer
-	if err := md.Convert(markdown, &buf); err != nil {
-		panic(err)
+	if err := md.Convert(body, io.Discard); err != nil {
+		return 0, err
+	}
+	if fm != nil && fm.Cut != nil && *fm.Cut {
+		if _, err := p.Cut(); err != nil {
+			return 0, err
+		}
+	}
+	if err := p.Print(); err != nil {
+		return 0, err
+	}
+	e.Layout = p.Layout
+
+	written := 0
+	for i := 0; i < copies; i++ {
+		n, err := e.WriteRaw(buf.Bytes())
+		written += n
+		if err != nil {
+			return written, err
+		}
 	}
+	return written, nil
+}
 
-	_, err := e.WriteRaw(buf.Bytes())
+// Prints an image centered on a line of its own.
+func (e *Escpos) printLogo(src string, o markdownOptions) error {
+	img, err := loadImage(src, o.imageDir, o.allowDataURI)
 	if err != nil {
-		return 0, err
+		return err
+	}
+	if _, err := e.WriteRaw([]byte{esc, 'a', JustifyCenter}); err != nil {
+		return err
+	}
+	if _, err := e.PrintImageFit(img); err != nil {
+		return err
 	}
-	return 0, nil
+	_, err = e.WriteRaw([]byte{esc, 'a', e.Style.Justify})
+	return err
 }
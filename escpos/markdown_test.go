@@ -0,0 +1,46 @@
+package escpos
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"io"
+	"testing"
+)
+
+// Renders arbitrary markdown, which may fail but must not panic, and must
+// leave the document of the caller as it was.
+func FuzzWriteMarkdown(f *testing.F) {
+	var logo bytes.Buffer
+	png.Encode(&logo, image.NewGray(image.Rect(0, 0, 8, 8)))
+	dataURI := "data:image/png;base64," + base64.StdEncoding.EncodeToString(logo.Bytes())
+
+	for _, seed := range []string{
+		"",
+		"# Title\n\nSome *emphasis*, **bold** and `code`.\n",
+		"- one\n- two\n  1. nested\n\n> quote\n\n---\n",
+		"| Item | Price |\n|:-----|------:|\n| Coffee | 3.50 |\n| ![alt](" + dataURI + ") | x |\n",
+		"Centered {: .center}\n\nText\n{: .right .bold}\n",
+		":::qr size=6 level=H https://example.com\n:::barcode type=ean13 400638133393\n:::feed 3\n:::drawer pin=2\n:::cut\n",
+		":::qr\nmulti\nline\n:::\n",
+		"---\nprofile: epson-tm-t88ii\ncopies: 2\ncut: true\nfooter: Thanks\nlogo: " + dataURI + "\n---\n# Receipt\n",
+		"---\ncode_page: wpc1252\n---\nCafé\n",
+		"<b>html</b> <img src=\"" + dataURI + "\"> [link](https://example.com) <https://example.com>\n",
+		"![missing](nope.png)\n\n![data](" + dataURI + ")\n",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, markdown []byte) {
+		// Room past the end shows writes into the array of the caller
+		buf := make([]byte, len(markdown), len(markdown)+64)
+		copy(buf, markdown)
+		before := bytes.Clone(buf[:cap(buf)])
+
+		e := New(io.Discard)
+		e.WriteMarkdown(buf, WithDataURIImages(true), WithLinkQRCodes(true))
+		if !bytes.Equal(buf[:cap(buf)], before) {
+			t.Errorf("WriteMarkdown changed the array of the document")
+		}
+	})
+}
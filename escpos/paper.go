@@ -0,0 +1,170 @@
+package escpos
+
+// Dots per millimetre of 203 dpi printers, to turn paper lengths in dots
+// into millimetres.
+const DotsPerMM = 8
+
+// Paper is what an ESC/POS stream uses of the paper roll and the cutter.
+type Paper struct {
+	Dots int // paper fed
+	Cuts int
+}
+
+// Returns the paper fed in millimetres.
+func (p Paper) Millimetres() float64 {
+	return float64(p.Dots) / DotsPerMM
+}
+
+const (
+	defaultLineSpacing   = 30 // after ESC @ and ESC 2, 1/6 inch
+	defaultBarcodeHeight = 162
+	fontAHeight          = 24
+	fontBHeight          = 17
+)
+
+// Byte capacities of QR code versions 1 to 40 with error correction level M.
+// The printer picks the smallest version that holds the data.
+var qrCapacities = []int{
+	14, 26, 42, 62, 84, 106, 122, 152, 180, 213, 251, 287, 331, 362, 412, 450, 504, 560, 624, 666,
+	711, 779, 857, 911, 997, 1059, 1125, 1190, 1264, 1370, 1452, 1538, 1628, 1722, 1809, 1911, 1989, 2099, 2213, 2331,
+}
+
+// paperState is what a printer in standard mode keeps that decides how far
+// the paper is fed.
+type paperState struct {
+	spacing  int // line spacing
+	font     int // height of a character
+	height   int // character height multiplier
+	line     int // height of what is on the current line
+	barcode  int // barcode height
+	hri      int // lines of HRI characters printed with barcodes
+	qrModule int // QR code module size
+	qrData   int // bytes of the stored QR code
+	graphics int // height of the stored graphics
+}
+
+func newPaperState() paperState {
+	return paperState{spacing: defaultLineSpacing, font: fontAHeight, height: 1, barcode: defaultBarcodeHeight, qrModule: 3}
+}
+
+// Estimates the paper an ESC/POS stream uses: lines of text at the line
+// spacing or the height of their characters, feeds, raster and bit images,
+// barcodes, QR codes and cuts. Page mode, NV images and other codes aren't
+// counted, nor commands after an error of ParseCommands.
+func EstimatePaper(data []byte) (Paper, error) {
+	cmds, err := ParseCommands(data)
+	var p Paper
+	s := newPaperState()
+	// Prints the current line and feeds the paper n dots, at least the
+	// height of the line
+	feed := func(n int) {
+		p.Dots += max(n, s.line)
+		s.line = 0
+	}
+	arg := func(c Command, i int) int {
+		if i < len(c.Data) {
+			return int(c.Data[i])
+		}
+		return 0
+	}
+	for _, c := range cmds {
+		switch c.Name {
+		case "":
+			s.line = max(s.line, s.font*s.height)
+		case "LF":
+			feed(s.spacing)
+		case "ESC J":
+			feed(arg(c, 2))
+		case "ESC d":
+			if n := arg(c, 2); n > 0 {
+				feed(s.spacing)
+				p.Dots += (n - 1) * s.spacing
+			} else {
+				feed(0)
+			}
+
+		case "ESC @":
+			s = newPaperState()
+		case "ESC 2":
+			s.spacing = defaultLineSpacing
+		case "ESC 3":
+			s.spacing = arg(c, 2)
+		case "ESC !":
+			n := arg(c, 2)
+			s.font, s.height = fontAHeight, 1
+			if n&0x01 != 0 {
+				s.font = fontBHeight
+			}
+			if n&0x10 != 0 {
+				s.height = 2
+			}
+		case "ESC M":
+			s.font = fontAHeight
+			if n := arg(c, 2); n == 1 || n == '1' {
+				s.font = fontBHeight
+			}
+		case "GS !":
+			s.height = arg(c, 2)&0x0f + 1
+
+		case "ESC *":
+			// One row of an 8 or 24 dot bit image on the current line
+			h := 8
+			if arg(c, 2) >= 32 {
+				h = 24
+			}
+			s.line = max(s.line, h)
+		case "GS v 0":
+			h := arg(c, 6) + arg(c, 7)<<8
+			if m := arg(c, 3); m == 2 || m == 3 || m == '2' || m == '3' {
+				h *= 2
+			}
+			feed(h)
+		case "GS ( L", "GS 8 L":
+			// fn 112 and 113 store graphics, 2 and 50 print them
+			i := 6
+			if c.Name == "GS 8 L" {
+				i = 8
+			}
+			switch arg(c, i) {
+			case 112, 113:
+				s.graphics = (arg(c, i+7) + arg(c, i+8)<<8) * max(arg(c, i+3), 1)
+			case 2, 50:
+				feed(s.graphics)
+			}
+
+		case "GS h":
+			s.barcode = arg(c, 2)
+		case "GS H":
+			s.hri = [4]int{0, 1, 1, 2}[arg(c, 2)&0x03]
+		case "GS k":
+			feed(s.barcode + s.hri*fontAHeight)
+		case "GS ( k":
+			if arg(c, 5) != 49 {
+				break // PDF417 and other codes
+			}
+			switch arg(c, 6) {
+			case 67:
+				s.qrModule = arg(c, 7)
+			case 80:
+				s.qrData = arg(c, 3) + arg(c, 4)<<8 - 3
+			case 81:
+				version := len(qrCapacities)
+				for v, capacity := range qrCapacities {
+					if s.qrData <= capacity {
+						version = v + 1
+						break
+					}
+				}
+				feed((17 + 4*version) * s.qrModule)
+			}
+
+		case "GS V":
+			// Function B feeds n dots before cutting
+			if m := arg(c, 2); m == 65 || m == 66 {
+				p.Dots += arg(c, 3)
+			}
+			p.Cuts++
+		}
+	}
+	return p, err
+}
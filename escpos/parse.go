@@ -0,0 +1,204 @@
+package escpos
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Command is a command of an ESC/POS stream with its parameters, or a run of
+// text between commands.
+type Command struct {
+	Name string // like "ESC @", "GS ( k" or "LF", empty for text
+	Data []byte
+}
+
+const (
+	dle byte = 0x10
+	can byte = 0x18
+)
+
+// Names of the single byte commands.
+var controlNames = map[byte]string{ht: "HT", '\n': "LF", '\f': "FF", '\r': "CR", can: "CAN"}
+
+// Lengths of the commands with fixed parameters, including the prefix.
+var commandLengths = map[byte]map[byte]int{
+	esc: {
+		'\f': 2, ' ': 3, '!': 3, '$': 4, '%': 3, '-': 3, '2': 2, '3': 3, '=': 3, '?': 3, '@': 2,
+		'E': 3, 'G': 3, 'J': 3, 'K': 3, 'L': 2, 'M': 3, 'R': 3, 'S': 2, 'T': 3, 'U': 3, 'V': 3,
+		'W': 10, '\\': 4, 'a': 3, 'c': 4, 'd': 3, 'e': 3, 'i': 2, 'm': 2, 'p': 5, 'r': 3, 't': 3,
+		'u': 3, 'v': 2, '{': 3,
+	},
+	gs: {
+		'!': 3, '$': 4, '/': 3, ':': 2, 'B': 3, 'E': 3, 'H': 3, 'I': 3, 'L': 4, 'P': 4, 'T': 3,
+		'W': 4, '\\': 4, '^': 5, 'a': 3, 'b': 3, 'c': 2, 'f': 3, 'g': 6, 'h': 3, 'j': 3, 'r': 3,
+		'w': 3,
+	},
+	fs: {
+		'!': 3, '&': 2, '-': 3, '.': 2, '?': 4, 'C': 3, 'S': 4, 'W': 3, 'p': 4,
+		'd': 4, // the NV bit image print of PrintNVBitImage
+	},
+}
+
+// Splits an ESC/POS stream into commands and text. Commands that aren't
+// known, or are cut off at the end of data, are an error, since the length
+// of their parameters isn't known. The commands returned so far are returned
+// with the error.
+func ParseCommands(data []byte) ([]Command, error) {
+	var cmds []Command
+	for i := 0; i < len(data); {
+		b := data[i]
+		switch {
+		case b == dle || b == esc || b == fs || b == gs:
+			name, n, err := commandLength(data[i:])
+			if err != nil {
+				return cmds, fmt.Errorf("offset %d: %w", i, err)
+			}
+			if n > len(data)-i {
+				return cmds, fmt.Errorf("offset %d: %s is cut off", i, name)
+			}
+			cmds = append(cmds, Command{Name: name, Data: data[i : i+n]})
+			i += n
+		case controlNames[b] != "":
+			cmds = append(cmds, Command{Name: controlNames[b], Data: data[i : i+1]})
+			i++
+		default:
+			j := i + 1
+			for j < len(data) && !isCommandStart(data[j]) {
+				j++
+			}
+			cmds = append(cmds, Command{Data: data[i:j]})
+			i = j
+		}
+	}
+	return cmds, nil
+}
+
+func isCommandStart(b byte) bool {
+	return b == dle || b == esc || b == fs || b == gs || controlNames[b] != ""
+}
+
+// Returns the name and length of the command at the start of b. The length
+// is len(b)+1 if the command is cut off.
+func commandLength(b []byte) (string, int, error) {
+	name, n, err := paramLength(b)
+	// Lengths are counted in 64 bits, so they can't overflow on 32 bit
+	// builds, and clamped before they are used as an int
+	return name, int(min(n, int64(len(b))+1)), err
+}
+
+// Returns the name and length of the command at the start of b, which can
+// be more than len(b) if the command is cut off.
+func paramLength(b []byte) (string, int64, error) {
+	// Reads byte i, or 0 past the end so a cut off command gets a length
+	at := func(i int64) int64 {
+		if i < int64(len(b)) {
+			return int64(b[i])
+		}
+		return 0
+	}
+	name := commandName(b, 2)
+	if len(b) < 2 {
+		return name, 2, nil
+	}
+
+	if n, ok := commandLengths[b[0]][b[1]]; ok {
+		return name, int64(n), nil
+	}
+	switch {
+	case b[0] == dle:
+		switch b[1] {
+		case 0x04, 0x05: // EOT, ENQ
+			return name, 3, nil
+		case 0x14: // DC4
+			switch at(2) {
+			case 7:
+				return name, 4, nil
+			case 8:
+				return name, 10, nil
+			}
+			return name, 5, nil
+		}
+
+	case b[0] == esc && b[1] == '&':
+		// ESC & y c1 c2, then for every character x and y*x bytes
+		n := int64(5)
+		for c := at(3); c <= at(4) && n < int64(len(b)); c++ {
+			n += 1 + at(2)*at(n)
+		}
+		return name, n, nil
+	case b[0] == esc && b[1] == '*':
+		k := at(3) + at(4)<<8
+		if at(2) >= 32 {
+			k *= 3
+		}
+		return name, 5 + k, nil
+	case b[0] == esc && b[1] == 'D':
+		// Tab positions up to a NUL
+		for n := 2; n < len(b); n++ {
+			if b[n] == 0 {
+				return name, int64(n) + 1, nil
+			}
+		}
+		return name, int64(len(b)) + 1, nil
+
+	case (b[0] == esc || b[0] == fs || b[0] == gs) && b[1] == '(':
+		// ESC ( A, GS ( k and the like: pL pH and that many bytes
+		return commandName(b, 3), 5 + at(3) + at(4)<<8, nil
+	case b[0] == gs && b[1] == '8' && at(2) == 'L':
+		return commandName(b, 3), 7 + at(3) + at(4)<<8 + at(5)<<16 + at(6)<<24, nil
+	case b[0] == gs && b[1] == '*':
+		return name, 4 + at(2)*at(3)*8, nil
+	case b[0] == gs && b[1] == 'V':
+		if m := at(2); m == 0 || m == 1 || m == 48 || m == 49 {
+			return name, 3, nil
+		}
+		return name, 4, nil
+	case b[0] == gs && b[1] == 'k':
+		if at(2) <= 6 {
+			// Data up to a NUL
+			for n := 3; n < len(b); n++ {
+				if b[n] == 0 {
+					return name, int64(n) + 1, nil
+				}
+			}
+			return name, int64(len(b)) + 1, nil
+		}
+		return name, 4 + at(3), nil
+	case b[0] == gs && b[1] == 'v' && at(2) == '0':
+		return commandName(b, 3), 8 + (at(4)+at(5)<<8)*(at(6)+at(7)<<8), nil
+
+	case b[0] == fs && b[1] == 'q':
+		// FS q n, then n images of xL xH yL yH and x*y*8 bytes
+		n := int64(3)
+		for i := int64(0); i < at(2) && n+4 <= int64(len(b)); i++ {
+			n += 4 + (at(n)+at(n+1)<<8)*(at(n+2)+at(n+3)<<8)*8
+		}
+		return name, n, nil
+	case b[0] == fs && b[1] == 'g':
+		// FS g 1 writes and FS g 2 reads the NV user memory
+		if at(2) == '1' {
+			return commandName(b, 3), 10 + at(8) + at(9)<<8, nil
+		}
+		return commandName(b, 3), 10, nil
+	}
+	return name, 0, fmt.Errorf("unknown command %s", name)
+}
+
+// Names of the bytes in command names that aren't printable.
+var byteNames = map[byte]string{dle: "DLE", esc: "ESC", fs: "FS", gs: "GS", 0x04: "EOT", 0x05: "ENQ", 0x14: "DC4", '\f': "FF", ' ': "SP"}
+
+// Returns the name of a command made of its first n bytes.
+func commandName(b []byte, n int) string {
+	var parts []string
+	for _, c := range b[:min(n, len(b))] {
+		switch {
+		case byteNames[c] != "":
+			parts = append(parts, byteNames[c])
+		case c > ' ' && c < 0x7f:
+			parts = append(parts, string(rune(c)))
+		default:
+			parts = append(parts, fmt.Sprintf("%d", c))
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,276 @@
+package escpos
+
+import (
+	"strings"
+)
+
+// TableBorder selects the characters tables are drawn with.
+type TableBorder uint8
+
+const (
+	BorderNone  TableBorder = iota // columns separated by a space
+	BorderASCII                    // +, - and | characters
+	BorderBox                      // box-drawing characters of code page 437, ASCII with other code pages
+)
+
+// TableCell is the styled content of a table cell.
+type TableCell []Span
+
+// Table describes a table to be printed with WriteTable.
+type Table struct {
+	// Align holds the alignment of each column: JustifyLeft, JustifyCenter or JustifyRight.
+	Align  []uint8
+	Header []TableCell // printed in bold, may be empty
+	Rows   [][]TableCell
+	Border TableBorder
+	// Truncate cuts cell content that doesn't fit its column instead of wrapping it.
+	Truncate bool
+}
+
+// Characters of a border: horizontal, vertical and the corners and junctions
+// of the top, middle and bottom rules, each as left, middle and right.
+type borderChars struct {
+	h, v  string
+	rules [3][3]string
+}
+
+const (
+	ruleTop = iota
+	ruleMiddle
+	ruleBottom
+)
+
+var (
+	asciiBorder = borderChars{
+		h: "-", v: "|",
+		rules: [3][3]string{{"+", "+", "+"}, {"+", "+", "+"}, {"+", "+", "+"}},
+	}
+	boxBorder = borderChars{
+		h: "\xc4", v: "\xb3",
+		rules: [3][3]string{{"\xda", "\xc2", "\xbf"}, {"\xc3", "\xc5", "\xb4"}, {"\xc0", "\xc1", "\xd9"}},
+	}
+)
+
+// Smallest width in characters a column is shrunk to before words are broken.
+const minColumnWidth = 4
+
+// Prints a table in the current style. Columns are sized to their content and
+// shrunk to fit the print area, wrapping or truncating cells that don't fit.
+// Cells are placed with absolute positions, so mixed fonts and sizes line up.
+func (e *Escpos) WriteTable(t Table) (int, error) {
+	saved := e.Style
+	defer func() { e.Style = saved }()
+	base := e.Style
+	base.Justify = JustifyLeft
+	cell := e.CellWidth(base)
+
+	rows := t.Rows
+	if len(t.Header) > 0 {
+		header := make([]TableCell, len(t.Header))
+		for i, c := range t.Header {
+			header[i] = make(TableCell, len(c))
+			for j, s := range c {
+				s.Style.Bold = true
+				header[i][j] = s
+			}
+		}
+		rows = append([][]TableCell{header}, rows...)
+	}
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+	if columns == 0 || cell == 0 {
+		return 0, nil
+	}
+
+	var border *borderChars
+	switch t.Border {
+	case BorderASCII:
+		border = &asciiBorder
+	case BorderBox:
+		// The characters are bytes of PC437, other code pages have letters
+		// there
+		border = &asciiBorder
+		if e.charset == "" || e.charset == CodePages["pc437"].Charset {
+			border = &boxBorder
+		}
+	}
+
+	widths := e.tableColumnWidths(rows, columns, cell, border != nil)
+
+	// Start of each column in dots
+	starts := make([]int, columns)
+	pos := 0
+	for i, w := range widths {
+		switch {
+		case border != nil && i == 0:
+			pos += 2 * cell // vertical bar and padding
+		case border != nil:
+			pos += 3 * cell // padding, vertical bar and padding
+		case i > 0:
+			pos += cell // gap
+		}
+		starts[i] = pos
+		pos += w * cell
+	}
+
+	written := 0
+	write := func(n int, err error) error {
+		written += n
+		return err
+	}
+	rule := func(which int) error {
+		if border == nil {
+			return nil
+		}
+		chars := border.rules[which]
+		var line strings.Builder
+		for i, w := range widths {
+			if i == 0 {
+				line.WriteString(chars[0])
+			} else {
+				line.WriteString(chars[1])
+			}
+			line.WriteString(strings.Repeat(border.h, w+2))
+		}
+		line.WriteString(chars[2])
+		line.WriteByte('\n')
+		e.Style = base
+		return write(e.writeBorder(line.String()))
+	}
+	// Vertical bar at x dots
+	bar := func(x int) error {
+		if _, err := e.AbsolutePosition(uint16(x)); err != nil {
+			return err
+		}
+		e.Style = base
+		return write(e.writeBorder(border.v))
+	}
+
+	if err := rule(ruleTop); err != nil {
+		return written, err
+	}
+	for r, row := range rows {
+		lines := make([][]Line, columns)
+		height := 1
+		for c := 0; c < columns && c < len(row); c++ {
+			lines[c] = e.WrapWidth(row[c], WrapOptions{}, widths[c]*cell)
+			if t.Truncate && len(lines[c]) > 1 {
+				lines[c] = lines[c][:1]
+			}
+			height = max(height, len(lines[c]))
+		}
+		for l := 0; l < height; l++ {
+			for c := 0; c < columns; c++ {
+				if border != nil {
+					if err := bar(starts[c] - 2*cell); err != nil {
+						return written, err
+					}
+				}
+				if l >= len(lines[c]) {
+					continue
+				}
+				line := lines[c][l]
+				offset := 0
+				if c < len(t.Align) {
+					free := widths[c]*cell - e.spansWidth(line)
+					switch t.Align[c] {
+					case JustifyCenter:
+						offset = free / 2
+					case JustifyRight:
+						offset = free
+					}
+				}
+				if err := write(e.writeSpansAt(starts[c]+offset, line)); err != nil {
+					return written, err
+				}
+			}
+			if border != nil {
+				if err := bar(pos + cell); err != nil {
+					return written, err
+				}
+			}
+			if _, err := e.WriteRaw([]byte{'\n'}); err != nil {
+				return written, err
+			}
+		}
+		if r == 0 && len(t.Header) > 0 && len(rows) > 1 {
+			if err := rule(ruleMiddle); err != nil {
+				return written, err
+			}
+		}
+	}
+	if err := rule(ruleBottom); err != nil {
+		return written, err
+	}
+	return written, nil
+}
+
+// Returns the width of each column in characters of cell dots. Columns get
+// their natural width if the table fits. Otherwise the widest columns are
+// narrowed, first down to their longest word and then down to a single character.
+func (e *Escpos) tableColumnWidths(rows [][]TableCell, columns, cell int, bordered bool) []int {
+	natural := make([]int, columns)
+	longestWord := make([]int, columns)
+	for _, row := range rows {
+		for c, content := range row {
+			natural[c] = max(natural[c], ceilDiv(e.spansWidth(content), cell))
+			for _, tok := range tokenize(content) {
+				if tok.kind == tokenWord {
+					longestWord[c] = max(longestWord[c], ceilDiv(e.spansWidth(tok.frags), cell))
+				}
+			}
+		}
+	}
+
+	overhead := columns - 1
+	if bordered {
+		overhead = 3*columns + 1
+	}
+	avail := e.LineWidth()/cell - overhead
+
+	widths := append([]int(nil), natural...)
+	total := 0
+	for _, w := range widths {
+		total += w
+	}
+	for _, floor := range []func(c int) int{
+		func(c int) int { return min(natural[c], max(longestWord[c], minColumnWidth)) },
+		func(c int) int { return 1 },
+	} {
+		for total > avail {
+			widest := -1
+			for c, w := range widths {
+				if w > floor(c) && (widest < 0 || w > widths[widest]) {
+					widest = c
+				}
+			}
+			if widest < 0 {
+				break
+			}
+			widths[widest]--
+			total--
+		}
+	}
+	return widths
+}
+
+// Writes border characters in the current style. They are bytes of the code
+// page already, which converting them as UTF-8 would break: the corner ─┐ is
+// also the UTF-8 of Ŀ.
+func (e *Escpos) writeBorder(chars string) (int, error) {
+	return e.writeStyled([]byte(chars))
+}
+
+// Moves to x dots from the start of the line and writes spans left justified.
+func (e *Escpos) writeSpansAt(x int, spans []Span) (int, error) {
+	if _, err := e.AbsolutePosition(uint16(max(x, 0))); err != nil {
+		return 0, err
+	}
+	return e.writeSpansLine(justifySpans(spans, JustifyLeft))
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
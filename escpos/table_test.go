@@ -0,0 +1,140 @@
+package escpos
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// The style commands Write sends before text, in the default style and bold.
+const (
+	plainStyle = "\x1bE\x00\x1b-\x00\x1dB\x00\x1bV\x00\x1b{\x00\x1ba\x00\x1bM\x00\x1b \x00\x1d!\x00"
+	boldStyle  = "\x1bE\x01\x1b-\x00\x1dB\x00\x1bV\x00\x1b{\x00\x1ba\x00\x1bM\x00\x1b \x00\x1d!\x00"
+)
+
+// Returns ESC $, the absolute position of the next text in dots.
+func at(dots int) string {
+	return string([]byte{esc, '$', byte(dots), byte(dots >> 8)})
+}
+
+func cells(texts ...string) []TableCell {
+	row := make([]TableCell, len(texts))
+	for i, text := range texts {
+		row[i] = TableCell{{Text: text, Style: Style{Width: 1, Height: 1}}}
+	}
+	return row
+}
+
+func TestWriteTable(t *testing.T) {
+	long := cells("A very long description of the item that wraps", "1")
+	for _, tt := range []struct {
+		name     string
+		dots     uint16
+		codePage string
+		table    Table
+		want     string
+	}{
+		{
+			name: "natural widths, bold header",
+			dots: 576,
+			table: Table{
+				Align:  []uint8{JustifyLeft, JustifyRight},
+				Header: cells("Item", "Price"),
+				Rows:   [][]TableCell{cells("Tea", "2.50"), cells("Coffee", "3.00")},
+			},
+			// Columns of 6 and 5 characters of 12 dots with a gap
+			want: at(0) + boldStyle + "Item" + at(84) + boldStyle + "Price\n" +
+				at(0) + plainStyle + "Tea" + at(96) + plainStyle + "2.50\n" +
+				at(0) + plainStyle + "Coffee" + at(96) + plainStyle + "3.00\n",
+		},
+		{
+			// 47 and 3 characters don't fit 32, the first column is shrunk to 28
+			name:  "shrunk",
+			dots:  384,
+			table: Table{Header: cells("Description", "Qty"), Rows: [][]TableCell{long}},
+			want: at(0) + boldStyle + "Description" + at(348) + boldStyle + "Qty\n" +
+				at(0) + plainStyle + "A very long description of" + at(348) + plainStyle + "1\n" +
+				at(0) + plainStyle + "the item that wraps\n",
+		},
+		{
+			name:  "truncated",
+			dots:  384,
+			table: Table{Rows: [][]TableCell{long}, Truncate: true},
+			// Without the header the second column is one character wide
+			want: at(0) + plainStyle + "A very long description of the" + at(372) + plainStyle + "1\n",
+		},
+		{
+			// The widest column is shrunk first, 27 and 27 characters to 15
+			// and 16
+			name:  "both shrunk",
+			dots:  384,
+			table: Table{Rows: [][]TableCell{cells("one two three four five six", "seven eight nine ten eleven")}},
+			want: at(0) + plainStyle + "one two three" + at(192) + plainStyle + "seven eight nine\n" +
+				at(0) + plainStyle + "four five six" + at(192) + plainStyle + "ten eleven\n",
+		},
+		{
+			// Words are broken when the columns can't be narrower otherwise,
+			// 15 and 21 characters to 15 and 16
+			name:  "words broken",
+			dots:  384,
+			table: Table{Rows: [][]TableCell{cells("Extraordinarily", "Incomprehensibilities")}},
+			want: at(0) + plainStyle + "Extraordinarily" + at(192) + plainStyle + "Incomprehensibil\n" +
+				at(192) + plainStyle + "ities\n",
+		},
+		{
+			name:  "ascii",
+			dots:  576,
+			table: Table{Header: cells("h", "i"), Rows: [][]TableCell{cells("a", "b")}, Border: BorderASCII},
+			want: plainStyle + "+---+---+\n" +
+				at(0) + plainStyle + "|" + at(24) + boldStyle + "h" + at(48) + plainStyle + "|" + at(72) + boldStyle + "i" + at(96) + plainStyle + "|\n" +
+				plainStyle + "+---+---+\n" +
+				at(0) + plainStyle + "|" + at(24) + plainStyle + "a" + at(48) + plainStyle + "|" + at(72) + plainStyle + "b" + at(96) + plainStyle + "|\n" +
+				plainStyle + "+---+---+\n",
+		},
+		{
+			name:  "box",
+			dots:  576,
+			table: Table{Header: cells("h", "i"), Rows: [][]TableCell{cells("a", "b")}, Border: BorderBox},
+			want: plainStyle + "\xda\xc4\xc4\xc4\xc2\xc4\xc4\xc4\xbf\n" +
+				at(0) + plainStyle + "\xb3" + at(24) + boldStyle + "h" + at(48) + plainStyle + "\xb3" + at(72) + boldStyle + "i" + at(96) + plainStyle + "\xb3\n" +
+				plainStyle + "\xc3\xc4\xc4\xc4\xc5\xc4\xc4\xc4\xb4\n" +
+				at(0) + plainStyle + "\xb3" + at(24) + plainStyle + "a" + at(48) + plainStyle + "\xb3" + at(72) + plainStyle + "b" + at(96) + plainStyle + "\xb3\n" +
+				plainStyle + "\xc0\xc4\xc4\xc4\xc1\xc4\xc4\xc4\xd9\n",
+		},
+		{
+			// The corners aren't converted like UTF-8 text
+			name:     "box in PC437",
+			dots:     576,
+			codePage: "pc437",
+			table:    Table{Rows: [][]TableCell{cells("a")}, Border: BorderBox},
+			want: "\x1bt\x00" + plainStyle + "\xda\xc4\xc4\xc4\xbf\n" +
+				at(0) + plainStyle + "\xb3" + at(24) + plainStyle + "a" + at(48) + plainStyle + "\xb3\n" +
+				plainStyle + "\xc0\xc4\xc4\xc4\xd9\n",
+		},
+		{
+			name:     "box in another code page",
+			dots:     576,
+			codePage: "wpc1252",
+			table:    Table{Rows: [][]TableCell{cells("a")}, Border: BorderBox},
+			want: "\x1bt\x10" + plainStyle + "+---+\n" +
+				at(0) + plainStyle + "|" + at(24) + plainStyle + "a" + at(48) + plainStyle + "|\n" +
+				plainStyle + "+---+\n",
+		},
+	} {
+		var out bytes.Buffer
+		e := New(&out)
+		e.SetConfig(PrinterConfig{DotsPerLine: tt.dots})
+		if tt.codePage != "" {
+			if _, err := e.SetCodePage(tt.codePage); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := e.WriteTable(tt.table); err != nil {
+			t.Fatal(err)
+		}
+		e.Print()
+		if got := out.String(); got != tt.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tt.name, strings.ReplaceAll(got, plainStyle, "<plain>"), strings.ReplaceAll(tt.want, plainStyle, "<plain>"))
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package escpos
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// TrueTypeFont is a TrueType or OpenType font used to print text as an image
+// instead of with the fonts built into the printer. This can print any size
+// and any script the font covers, but text is not shaped, so scripts that
+// need joining or reordering, like Arabic, print as isolated glyphs.
+type TrueTypeFont struct {
+	font *opentype.Font
+}
+
+// Loads a TrueType or OpenType font from a file.
+func LoadTrueTypeFont(path string) (*TrueTypeFont, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTrueTypeFont(data)
+}
+
+// Parses a TrueType or OpenType font.
+func ParseTrueTypeFont(data []byte) (*TrueTypeFont, error) {
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse font: %w", err)
+	}
+	return &TrueTypeFont{font: f}, nil
+}
+
+// Returns a face of the font at size dots per em. Faces keep a glyph cache
+// and can't be used concurrently, so every render gets its own.
+func (f *TrueTypeFont) face(size float64) (font.Face, error) {
+	// At 72 DPI a point is a dot
+	return opentype.NewFace(f.font, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// Prints text rasterized with a TrueType font at size dots per em, wrapped to
+// the print area and justified like the current style.
+func (e *Escpos) WriteTrueType(text string, f *TrueTypeFont, size float64) (int, error) {
+	return e.WriteTrueTypeSpans([]Span{{Text: text, Style: e.Style}}, WrapOptions{}, f, size)
+}
+
+// Lays out spans like WriteSpans, but rasterized with a TrueType font at size
+// dots per em. Bold, underline and reverse of the span styles are drawn, the
+// justification of the first span applies to all lines, and the character
+// size and font are ignored.
+func (e *Escpos) WriteTrueTypeSpans(spans []Span, opts WrapOptions, f *TrueTypeFont, size float64) (int, error) {
+	face, err := f.face(size)
+	if err != nil {
+		return 0, err
+	}
+	defer face.Close()
+	measure := func(s Span) int {
+		w := font.MeasureString(face, s.Text).Ceil()
+		if s.Style.Bold && w > 0 {
+			w++
+		}
+		return w
+	}
+	// Raster images are printed in bands of 8 dots
+	width := e.LineWidth() / 8 * 8
+	lines := wrapMeasured(spans, opts, width, measure)
+	if len(lines) == 0 || width == 0 {
+		return 0, nil
+	}
+	justify := e.Style.Justify
+	if len(spans) > 0 {
+		justify = spans[0].Style.Justify
+	}
+
+	metrics := face.Metrics()
+	ascent, lineHeight := metrics.Ascent.Ceil(), metrics.Height.Ceil()
+	height := (len(lines)*lineHeight + 7) / 8 * 8
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	for i, line := range lines {
+		x := 0
+		lineWidth := 0
+		for _, s := range line {
+			lineWidth += measure(s)
+		}
+		switch justify {
+		case JustifyCenter:
+			x = (width - lineWidth) / 2
+		case JustifyRight:
+			x = width - lineWidth
+		}
+		top := i * lineHeight
+		for _, s := range line {
+			w := measure(s)
+			ink := color.Gray{Y: 0}
+			if s.Style.Reverse {
+				draw.Draw(img, image.Rect(x, top, x+w, top+lineHeight), image.Black, image.Point{}, draw.Src)
+				ink = color.Gray{Y: 0xff}
+			}
+			d := &font.Drawer{Dst: img, Src: image.NewUniform(ink), Face: face, Dot: fixed.P(x, top+ascent)}
+			d.DrawString(s.Text)
+			if s.Style.Bold {
+				// Draw again one dot to the right for a heavier stroke
+				d.Dot = fixed.P(x+1, top+ascent)
+				d.DrawString(s.Text)
+			}
+			if s.Style.Underline > 0 {
+				thickness := int(s.Style.Underline)
+				for y := top + ascent + 2; y < top+ascent+2+thickness && y < top+lineHeight; y++ {
+					for ux := x; ux < x+w; ux++ {
+						img.SetGray(ux, y, ink)
+					}
+				}
+			}
+			x += w
+		}
+	}
+	return e.PrintImage(img)
+}
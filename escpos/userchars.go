@@ -0,0 +1,114 @@
+package escpos
+
+import (
+	"fmt"
+	"image"
+	"unicode/utf8"
+)
+
+// Dimensions of user-defined characters. The height is always 24 dots (3 bytes per column).
+const (
+	userCharBytesPerColumn      = 3
+	userCharHeight              = userCharBytesPerColumn * 8
+	userCharFirst          byte = 32
+	userCharLast           byte = 126
+)
+
+// Downloads glyph as the user-defined character for code in the currently selected font.
+// code must be between 32 and 126. The glyph is converted to black and white, clipped to
+// 24 dots high and to the width of the font (12 dots for Font A, 9 dots for Font B).
+// User-defined characters are only printed after enabling them with UserDefinedCharacters.
+func (e *Escpos) DefineCharacter(code byte, glyph image.Image) (int, error) {
+	if code < userCharFirst || code > userCharLast {
+		return 0, fmt.Errorf("user-defined character codes must be between %d and %d", userCharFirst, userCharLast)
+	}
+	data := userCharData(glyph, fontDotWidth(e.Style.Font))
+	cmd := []byte{esc, '&', userCharBytesPerColumn, code, code, byte(len(data) / userCharBytesPerColumn)}
+	return e.WriteRaw(append(cmd, data...))
+}
+
+// Downloads glyph as the user-defined character for code and prints it wherever r occurs
+// in text passed to Write. Enables user-defined characters, so code no longer prints
+// its regular character. Pick a code that your text does not otherwise use.
+func (e *Escpos) DefineRune(r rune, code byte, glyph image.Image) (int, error) {
+	written, err := e.DefineCharacter(code, glyph)
+	if err != nil {
+		return written, err
+	}
+	if e.userRunes == nil {
+		e.userRunes = make(map[rune]byte)
+	}
+	e.userRunes[r] = code
+	_, err = e.UserDefinedCharacters(true)
+	return written, err
+}
+
+// Selects (true) or cancels (false) the user-defined character set.
+func (e *Escpos) UserDefinedCharacters(p bool) (int, error) {
+	return e.WriteRaw([]byte{esc, '%', boolToByte(p)})
+}
+
+// Deletes the user-defined character for code and forgets any rune mapped to it.
+func (e *Escpos) DeleteCharacter(code byte) (int, error) {
+	for r, c := range e.userRunes {
+		if c == code {
+			delete(e.userRunes, r)
+		}
+	}
+	return e.WriteRaw([]byte{esc, '?', code})
+}
+
+// Replaces runes defined with DefineRune by their character code. Bytes that
+// are not valid UTF-8, like text already converted to GBK, are kept as is.
+func (e *Escpos) replaceUserRunes(data string) []byte {
+	if len(e.userRunes) == 0 {
+		return []byte(data)
+	}
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRuneInString(data[i:])
+		if code, ok := e.userRunes[r]; ok && r != utf8.RuneError {
+			out = append(out, code)
+		} else {
+			out = append(out, data[i:i+size]...)
+		}
+		i += size
+	}
+	return out
+}
+
+// Converts a glyph to column-major character data: each column is 3 bytes, top to bottom,
+// most significant bit first.
+func userCharData(glyph image.Image, maxWidth int) []byte {
+	width, height, pixels := getPixels(glyph)
+	if width == 0 || height == 0 {
+		return nil
+	}
+	removeTransparency(&pixels)
+	makeGrayscale(&pixels)
+
+	if width > maxWidth {
+		width = maxWidth
+	}
+	if height > userCharHeight {
+		height = userCharHeight
+	}
+
+	data := make([]byte, width*userCharBytesPerColumn)
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			if getPixelValue(x, y, &pixels) == 1 {
+				data[x*userCharBytesPerColumn+y/8] |= 0x80 >> (y % 8)
+			}
+		}
+	}
+	return data
+}
+
+// Returns the width of a character cell in dots for a font.
+func fontDotWidth(font uint8) int {
+	if font == FontB {
+		return 9
+	}
+	return 12
+}
@@ -3,14 +3,20 @@ module github.com/petertjmills/escpos-server
 go 1.24.3
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/PuerkitoBio/goquery v1.10.3
 	github.com/google/gousb v1.1.3
 	github.com/joho/godotenv v1.5.1
 	github.com/qiniu/iconv v1.2.0
 	github.com/yuin/goldmark v1.7.12
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/image v0.32.0
+	golang.org/x/net v0.39.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/andybalholm/cascadia v1.3.3 // indirect
-	golang.org/x/net v0.39.0 // indirect
+	golang.org/x/sys v0.32.0 // indirect
+	golang.org/x/text v0.30.0 // indirect
 )
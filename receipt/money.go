@@ -0,0 +1,74 @@
+package receipt
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in the minor unit of a currency, e.g. cents.
+type Money int64
+
+// Currency describes how amounts are formatted. Symbols outside ASCII, like
+// £ and €, need a code page that has them, see Escpos.SetCodePage.
+type Currency struct {
+	Symbol      string
+	SymbolAfter bool // print the symbol after the amount, separated by a space
+	Decimals    int  // digits of the minor unit
+	Decimal     string
+	Thousands   string
+}
+
+var (
+	USD = Currency{Symbol: "$", Decimals: 2, Decimal: ".", Thousands: ","}
+	GBP = Currency{Symbol: "£", Decimals: 2, Decimal: ".", Thousands: ","}
+	EUR = Currency{Symbol: "€", SymbolAfter: true, Decimals: 2, Decimal: ",", Thousands: "."}
+	JPY = Currency{Symbol: "¥", Decimals: 0, Thousands: ","}
+)
+
+// Converts an amount in major units, like 3.50, to Money, rounding to the
+// nearest minor unit.
+func (c Currency) Amount(v float64) Money {
+	return Money(math.Round(v * math.Pow10(c.Decimals)))
+}
+
+// Formats an amount with the currency symbol, e.g. "$1,234.50" or "-€3,00".
+func (c Currency) Format(m Money) string {
+	s := c.FormatNumber(m)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	if c.SymbolAfter {
+		return sign + s + " " + c.Symbol
+	}
+	return sign + c.Symbol + s
+}
+
+// Formats an amount without the currency symbol, e.g. "1,234.50".
+func (c Currency) FormatNumber(m Money) string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	unit := int64(math.Pow10(c.Decimals))
+	major, minor := v/unit, v%unit
+
+	digits := []byte(strconv.FormatInt(major, 10))
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(c.Thousands)
+		}
+		b.WriteByte(d)
+	}
+	if c.Decimals > 0 {
+		b.WriteString(c.Decimal)
+		frac := strconv.FormatInt(minor, 10)
+		b.WriteString(strings.Repeat("0", c.Decimals-len(frac)))
+		b.WriteString(frac)
+	}
+	return b.String()
+}
@@ -0,0 +1,310 @@
+// Package receipt lays out point of sale receipts on an ESC/POS printer.
+//
+//	r := receipt.New(p, receipt.USD)
+//	r.Header("Corner Cafe", "1 Main Street").
+//		Item("Coffee", 2, 350).
+//		Item("Sandwich", 1, 800).
+//		Rule().
+//		Total("Total", r.ItemsTotal()).
+//		Payment("Card", r.ItemsTotal()).
+//		Barcode("0001-2345").
+//		Cut()
+//	err := r.Print()
+package receipt
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"github.com/petertjmills/escpos-server/escpos"
+)
+
+// Receipt builds a receipt from components that lay themselves out for the
+// print area of the printer and format amounts in the receipt's currency.
+// Methods can be chained. After the first error nothing more is written and
+// the error is returned by Err and Print.
+type Receipt struct {
+	p        *escpos.Escpos
+	currency Currency
+	items    Money // sum of the line items so far
+	err      error
+}
+
+// Tax is a line of a tax breakdown: the net amount taxed at a rate in percent.
+type Tax struct {
+	Label string // e.g. "A" or "VAT 20%"
+	Rate  float64
+	Net   Money
+}
+
+// Returns the tax on the net amount, rounded to the minor unit.
+func (t Tax) Amount() Money {
+	return Money(math.Round(float64(t.Net) * t.Rate / 100))
+}
+
+// Creates a receipt printed to p with amounts formatted in currency c.
+func New(p *escpos.Escpos, c Currency) *Receipt {
+	return &Receipt{p: p, currency: c}
+}
+
+// Returns the first error that occurred while building the receipt.
+func (r *Receipt) Err() error {
+	return r.err
+}
+
+// Sends the receipt to the printer.
+func (r *Receipt) Print() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.p.Print()
+}
+
+// Returns the sum of the line items added so far.
+func (r *Receipt) ItemsTotal() Money {
+	return r.items
+}
+
+// Runs a component with the style of the printer restored afterwards.
+func (r *Receipt) do(fn func(p *escpos.Escpos) error) *Receipt {
+	if r.err != nil {
+		return r
+	}
+	saved := r.p.Style
+	r.err = fn(r.p)
+	r.p.Style = saved
+	return r
+}
+
+// Prints an image centered, scaled down to the paper width if needed.
+func (r *Receipt) Logo(img image.Image) *Receipt {
+	return r.do(func(p *escpos.Escpos) error {
+		p.Justify(escpos.JustifyCenter)
+		if _, err := p.PrintImageFit(img); err != nil {
+			return err
+		}
+		_, err := p.Write("\n")
+		return err
+	})
+}
+
+// Prints the title centered in double size, followed by centered lines like
+// the address.
+func (r *Receipt) Header(title string, lines ...string) *Receipt {
+	return r.do(func(p *escpos.Escpos) error {
+		p.Justify(escpos.JustifyCenter)
+		p.Bold(true).Size(2, 2)
+		if _, err := p.WriteWrapped(title); err != nil {
+			return err
+		}
+		p.Bold(false).Size(1, 1)
+		for _, line := range lines {
+			if _, err := p.WriteWrapped(line); err != nil {
+				return err
+			}
+		}
+		_, err := p.Write("\n")
+		return err
+	})
+}
+
+// Prints text wrapped to the paper width.
+func (r *Receipt) Text(text string) *Receipt {
+	return r.do(func(p *escpos.Escpos) error {
+		_, err := p.WriteWrapped(text)
+		return err
+	})
+}
+
+// Prints a line item with its total on the right. For more than one unit the
+// quantity and unit price follow on a second line.
+func (r *Receipt) Item(name string, qty int, unit Money) *Receipt {
+	total := Money(qty) * unit
+	r.items += total
+	return r.do(func(p *escpos.Escpos) error {
+		if err := r.columns(p, name, r.currency.Format(total)); err != nil {
+			return err
+		}
+		if qty == 1 {
+			return nil
+		}
+		_, err := p.Write(fmt.Sprintf("  %d x %s\n", qty, r.currency.Format(unit)))
+		return err
+	})
+}
+
+// Prints key on the left and value on the right.
+func (r *Receipt) Row(key, value string) *Receipt {
+	return r.do(func(p *escpos.Escpos) error {
+		return r.columns(p, key, value)
+	})
+}
+
+// Prints key and value joined by a dotted leader, like a table of contents.
+func (r *Receipt) Leader(key, value string) *Receipt {
+	return r.do(func(p *escpos.Escpos) error {
+		dots := p.Columns() - escpos.StringWidth(key) - escpos.StringWidth(value) - 2
+		if dots < 3 {
+			return r.columns(p, key, value)
+		}
+		_, err := p.Write(key + " " + strings.Repeat(".", dots) + " " + value + "\n")
+		return err
+	})
+}
+
+// Prints a dashed line across the paper.
+func (r *Receipt) Rule() *Receipt {
+	return r.rule("-")
+}
+
+// Prints a double line across the paper.
+func (r *Receipt) DoubleRule() *Receipt {
+	return r.rule("=")
+}
+
+func (r *Receipt) rule(char string) *Receipt {
+	return r.do(func(p *escpos.Escpos) error {
+		p.Justify(escpos.JustifyLeft)
+		_, err := p.Write(strings.Repeat(char, p.Columns()) + "\n")
+		return err
+	})
+}
+
+// Prints the sum of the line items so far.
+func (r *Receipt) Subtotal(label string) *Receipt {
+	return r.Row(label, r.currency.Format(r.items))
+}
+
+// Prints a total in bold, double height text.
+func (r *Receipt) Total(label string, amount Money) *Receipt {
+	return r.do(func(p *escpos.Escpos) error {
+		p.Bold(true).Size(1, 2)
+		return r.columns(p, label, r.currency.Format(amount))
+	})
+}
+
+// Prints a tax breakdown with the net, tax and gross amount of every rate.
+func (r *Receipt) Taxes(taxes ...Tax) *Receipt {
+	return r.do(func(p *escpos.Escpos) error {
+		p.Justify(escpos.JustifyLeft)
+		cell := func(text string) escpos.TableCell {
+			return escpos.TableCell{{Text: text, Style: p.Style}}
+		}
+		t := escpos.Table{
+			Align:  []uint8{escpos.JustifyLeft, escpos.JustifyRight, escpos.JustifyRight, escpos.JustifyRight, escpos.JustifyRight},
+			Header: []escpos.TableCell{cell("Tax"), cell("Rate"), cell("Net"), cell("Tax"), cell("Gross")},
+		}
+		for _, tax := range taxes {
+			amount := tax.Amount()
+			t.Rows = append(t.Rows, []escpos.TableCell{
+				cell(tax.Label),
+				cell(fmt.Sprintf("%g%%", tax.Rate)),
+				cell(r.currency.FormatNumber(tax.Net)),
+				cell(r.currency.FormatNumber(amount)),
+				cell(r.currency.FormatNumber(tax.Net + amount)),
+			})
+		}
+		_, err := p.WriteTable(t)
+		return err
+	})
+}
+
+// Prints a payment, like "Card" or "Cash", with its amount.
+func (r *Receipt) Payment(method string, amount Money) *Receipt {
+	return r.Row(method, r.currency.Format(amount))
+}
+
+// Prints a centered Code 128 barcode with its text below.
+func (r *Receipt) Barcode(code string) *Receipt {
+	return r.do(func(p *escpos.Escpos) error {
+		p.Justify(escpos.JustifyCenter)
+		// Writing nothing applies the justification
+		if _, err := p.Write(""); err != nil {
+			return err
+		}
+		if _, err := p.HRIPosition(2); err != nil {
+			return err
+		}
+		if _, err := p.Code128(code); err != nil {
+			return err
+		}
+		_, err := p.Write("\n")
+		return err
+	})
+}
+
+// Prints a centered QR code.
+func (r *Receipt) QRCode(data string) *Receipt {
+	return r.do(func(p *escpos.Escpos) error {
+		p.Justify(escpos.JustifyCenter)
+		// Writing nothing applies the justification
+		if _, err := p.Write(""); err != nil {
+			return err
+		}
+		_, err := p.QRCode(data, true, 6, escpos.QRCodeErrorCorrectionLevelM)
+		return err
+	})
+}
+
+// Prints centered lines, like a thank you note.
+func (r *Receipt) Footer(lines ...string) *Receipt {
+	return r.do(func(p *escpos.Escpos) error {
+		p.Justify(escpos.JustifyCenter)
+		if _, err := p.Write("\n"); err != nil {
+			return err
+		}
+		for _, line := range lines {
+			if _, err := p.WriteWrapped(line); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Feeds the paper by n lines.
+func (r *Receipt) Feed(n uint8) *Receipt {
+	return r.do(func(p *escpos.Escpos) error {
+		_, err := p.LineFeedD(n)
+		return err
+	})
+}
+
+// Cuts the paper.
+func (r *Receipt) Cut() *Receipt {
+	return r.do(func(p *escpos.Escpos) error {
+		_, err := p.Cut()
+		return err
+	})
+}
+
+// Prints left and right on the same line, the left text wrapped so it doesn't
+// run into the right. If right takes more than half the line it goes on a
+// line of its own. Text is measured in dots, so wide runes take two cells.
+func (r *Receipt) columns(p *escpos.Escpos, left, right string) error {
+	p.Justify(escpos.JustifyLeft)
+	width, cell := p.LineWidth(), p.CellWidth(p.Style)
+	rw := escpos.StringWidth(right) * cell
+	avail := width - rw - cell
+	alone := avail < width/2
+	if alone {
+		avail = width
+	}
+	var lines []string
+	for _, line := range p.WrapWidth([]escpos.Span{{Text: left, Style: p.Style}}, escpos.WrapOptions{}, avail) {
+		lines = append(lines, line.String())
+	}
+	// Spaces up to where right starts
+	pad := func(text string) string {
+		return strings.Repeat(" ", max(width-rw-escpos.StringWidth(text)*cell, 0)/cell)
+	}
+	if alone {
+		lines = append(lines, pad("")+right)
+	} else {
+		lines[0] += pad(lines[0]) + right
+	}
+	_, err := p.Write(strings.Join(lines, "\n") + "\n")
+	return err
+}
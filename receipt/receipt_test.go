@@ -0,0 +1,140 @@
+package receipt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/petertjmills/escpos-server/escpos"
+)
+
+// The style commands written before text, in the default style and bold.
+const (
+	plain = "\x1bE\x00\x1b-\x00\x1dB\x00\x1bV\x00\x1b{\x00\x1ba\x00\x1bM\x00\x1b \x00\x1d!\x00"
+	bold  = "\x1bE\x01\x1b-\x00\x1dB\x00\x1bV\x00\x1b{\x00\x1ba\x00\x1bM\x00\x1b \x00\x1d!\x00"
+)
+
+// Paper widths in dots, 32 and 48 columns of Font A.
+const (
+	paper58 = 384
+	paper80 = 576
+)
+
+func sp(n int) string {
+	return strings.Repeat(" ", n)
+}
+
+// Returns ESC $, the absolute position of the next text in dots.
+func pos(dots int) string {
+	return string([]byte{0x1b, '$', byte(dots), byte(dots >> 8)})
+}
+
+// A component of a receipt and what it prints on 58mm and 80mm paper.
+type component struct {
+	name           string
+	add            func(r *Receipt)
+	want58, want80 string
+}
+
+func testComponents(t *testing.T, tests []component) {
+	t.Helper()
+	for _, tt := range tests {
+		for _, paper := range []struct {
+			dots uint16
+			want string
+		}{{paper58, tt.want58}, {paper80, tt.want80}} {
+			var out bytes.Buffer
+			p := escpos.New(&out)
+			p.SetConfig(escpos.PrinterConfig{DotsPerLine: paper.dots})
+			r := New(p, USD)
+			tt.add(r)
+			if err := r.Print(); err != nil {
+				t.Fatal(err)
+			}
+			if got := out.String(); got != paper.want {
+				t.Errorf("%s at %d dots:\ngot  %q\nwant %q", tt.name, paper.dots, got, paper.want)
+			}
+		}
+	}
+}
+
+func TestItem(t *testing.T) {
+	testComponents(t, []component{
+		{
+			name:   "one unit",
+			add:    func(r *Receipt) { r.Item("Coffee", 1, 350) },
+			want58: plain + "Coffee" + sp(21) + "$3.50\n",
+			want80: plain + "Coffee" + sp(37) + "$3.50\n",
+		},
+		{
+			name:   "units",
+			add:    func(r *Receipt) { r.Item("Coffee", 2, 350) },
+			want58: plain + "Coffee" + sp(21) + "$7.00\n" + plain + "  2 x $3.50\n",
+			want80: plain + "Coffee" + sp(37) + "$7.00\n" + plain + "  2 x $3.50\n",
+		},
+		{
+			name:   "long name",
+			add:    func(r *Receipt) { r.Item("Chicken and avocado sandwich on rye", 1, 800) },
+			want58: plain + "Chicken and avocado" + sp(8) + "$8.00\nsandwich on rye\n",
+			want80: plain + "Chicken and avocado sandwich on rye" + sp(8) + "$8.00\n",
+		},
+		{
+			// 18 wide runes take 36 columns
+			name:   "wide runes",
+			add:    func(r *Receipt) { r.Item("特製醤油拉麺と味玉と焼豚の盛り合わせ", 1, 1250) },
+			want58: plain + "特製醤油拉麺と味玉と焼豚" + sp(2) + "$12.50\nの盛り合わせ\n",
+			want80: plain + "特製醤油拉麺と味玉と焼豚の盛り合わせ" + sp(6) + "$12.50\n",
+		},
+	})
+}
+
+func TestRow(t *testing.T) {
+	testComponents(t, []component{
+		{
+			name:   "short",
+			add:    func(r *Receipt) { r.Row("Card", "$7.00") },
+			want58: plain + "Card" + sp(23) + "$7.00\n",
+			want80: plain + "Card" + sp(39) + "$7.00\n",
+		},
+		{
+			// More than half of 32 columns, less than half of 48
+			name:   "long value",
+			add:    func(r *Receipt) { r.Row("Ref", "ORDER-2024-000123-AB") },
+			want58: plain + "Ref\n" + sp(12) + "ORDER-2024-000123-AB\n",
+			want80: plain + "Ref" + sp(25) + "ORDER-2024-000123-AB\n",
+		},
+	})
+}
+
+func TestLeader(t *testing.T) {
+	testComponents(t, []component{
+		{
+			name:   "short",
+			add:    func(r *Receipt) { r.Leader("Tip", "$1.00") },
+			want58: plain + "Tip " + strings.Repeat(".", 22) + " $1.00\n",
+			want80: plain + "Tip " + strings.Repeat(".", 38) + " $1.00\n",
+		},
+		{
+			// Less than three dots fit on 58mm, so it is laid out as a row
+			name:   "long key",
+			add:    func(r *Receipt) { r.Leader("Service charge for large groups", "$12.00") },
+			want58: plain + "Service charge for large" + sp(2) + "$12.00\ngroups\n",
+			want80: plain + "Service charge for large groups " + strings.Repeat(".", 9) + " $12.00\n",
+		},
+	})
+}
+
+func TestTaxes(t *testing.T) {
+	// The table is sized to its content, 32 columns, on both papers
+	want := pos(0) + bold + "Tax" + pos(48) + bold + "Rate" + pos(168) + bold + "Net" + pos(240) + bold + "Tax" + pos(324) + bold + "Gross\n" +
+		pos(0) + plain + "A" + pos(60) + plain + "20%" + pos(144) + plain + "10.00" + pos(228) + plain + "2.00" + pos(324) + plain + "12.00\n" +
+		pos(0) + plain + "B" + pos(48) + plain + "5.5%" + pos(108) + plain + "1,234.56" + pos(216) + plain + "67.90" + pos(288) + plain + "1,302.46\n"
+	testComponents(t, []component{
+		{
+			name:   "two rates",
+			add:    func(r *Receipt) { r.Taxes(Tax{"A", 20, 1000}, Tax{"B", 5.5, 123456}) },
+			want58: want,
+			want80: want,
+		},
+	})
+}
@@ -0,0 +1,249 @@
+// Package templates renders receipts from named text/template templates.
+//
+// Templates are usually markdown for Escpos.WriteMarkdown, and get functions
+// for laying out receipts:
+//
+//	pad 16 .Name         left align in 16 columns, cutting longer text
+//	padLeft 8 .Qty       right align in 8 columns
+//	center .Title        center in the line width
+//	right .Total         right align in the line width
+//	wrap .Text           wrap to the line width
+//	money .Price         format an amount in the currency of the set
+//	date "long" .Time    format a time as short, long, time, datetime or a Go layout
+//	qr .URL              a :::qr markdown directive
+//	barcode "ean13" .EAN a :::barcode markdown directive, the type is optional
+//
+// Markdown collapses spaces and treats four leading spaces as code, so pad,
+// center and right are meant for code blocks and plain text. In markdown
+// paragraphs use attributes like {: .center} instead.
+package templates
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/petertjmills/escpos-server/escpos"
+	"github.com/petertjmills/escpos-server/receipt"
+)
+
+// Set is a set of named templates that share functions and can include each
+// other with {{ template "name" . }}.
+type Set struct {
+	columns  int
+	currency receipt.Currency
+	root     *template.Template
+}
+
+// Option configures a Set.
+type Option func(*Set)
+
+// Sets the line width in characters used by center, right and wrap. The
+// default is 48, the width of Font A on 80mm paper.
+func WithColumns(n int) Option {
+	return func(s *Set) {
+		s.columns = n
+	}
+}
+
+// Sets the currency used by money. The default is receipt.USD.
+func WithCurrency(c receipt.Currency) Option {
+	return func(s *Set) {
+		s.currency = c
+	}
+}
+
+// Returns an empty set.
+func New(opts ...Option) *Set {
+	s := &Set{columns: 48, currency: receipt.USD}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.root = template.New("").Funcs(s.Funcs())
+	return s
+}
+
+// Loads the *.tmpl files in dir into a new set, see ParseFS.
+func Load(dir string, opts ...Option) (*Set, error) {
+	s := New(opts...)
+	if err := s.ParseFS(os.DirFS(dir), "*.tmpl"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Adds a template, replacing any template of the same name.
+func (s *Set) Parse(name, text string) error {
+	if _, err := s.root.New(name).Parse(text); err != nil {
+		return fmt.Errorf("failed to parse template %s: %w", name, err)
+	}
+	return nil
+}
+
+// Adds the files of fsys matching pattern. A template is named after its file
+// up to the first dot, so weather.md.tmpl is the template "weather".
+func (s *Set) ParseFS(fsys fs.FS, pattern string) error {
+	files, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		text, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		name, _, _ := strings.Cut(path.Base(file), ".")
+		if err := s.Parse(name, string(text)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the names of the templates in the set, sorted.
+func (s *Set) Names() []string {
+	var names []string
+	for _, t := range s.root.Templates() {
+		if t.Name() != "" {
+			names = append(names, t.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Executes the named template with data and writes the output to w.
+func (s *Set) Execute(w io.Writer, name string, data any) error {
+	t := s.root.Lookup(name)
+	if t == nil {
+		return fmt.Errorf("no template %q", name)
+	}
+	return t.Execute(w, data)
+}
+
+// Executes the named template with data and returns the output.
+func (s *Set) Render(name string, data any) (string, error) {
+	var b strings.Builder
+	err := s.Execute(&b, name, data)
+	return b.String(), err
+}
+
+// Returns the template functions of the set, for use with other templates.
+func (s *Set) Funcs() template.FuncMap {
+	return template.FuncMap{
+		"pad":     pad,
+		"padLeft": padLeft,
+		"center": func(text string) string {
+			return strings.Repeat(" ", max(s.columns-escpos.StringWidth(text), 0)/2) + text
+		},
+		"right": func(text string) string {
+			return padLeft(s.columns, text)
+		},
+		"wrap":  s.wrap,
+		"money": s.money,
+		"date":  date,
+		"qr": func(data string) string {
+			return ":::qr " + data
+		},
+		"barcode": func(args ...string) (string, error) {
+			switch len(args) {
+			case 1:
+				return ":::barcode " + args[0], nil
+			case 2:
+				return ":::barcode type=" + args[0] + " " + args[1], nil
+			}
+			return "", fmt.Errorf("barcode takes an optional type and the data")
+		},
+	}
+}
+
+// Wraps text to the line width like Escpos.WriteWrapped, in Font A.
+func (s *Set) wrap(text string) string {
+	e := escpos.New(io.Discard)
+	e.Layout.PrintAreaWidth = uint16(s.columns * e.CellWidth(e.Style))
+	var lines []string
+	for _, line := range e.Wrap([]escpos.Span{{Text: text, Style: e.Style}}, escpos.WrapOptions{}) {
+		lines = append(lines, line.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Left aligns text in width columns, cutting it if it is longer.
+func pad(width int, text string) string {
+	text = truncate(text, width)
+	return text + strings.Repeat(" ", width-escpos.StringWidth(text))
+}
+
+// Right aligns text in width columns, cutting it if it is longer.
+func padLeft(width int, text string) string {
+	text = truncate(text, width)
+	return strings.Repeat(" ", width-escpos.StringWidth(text)) + text
+}
+
+func truncate(text string, width int) string {
+	w := 0
+	for i, r := range text {
+		w += escpos.RuneWidth(r)
+		if w > width {
+			return text[:i]
+		}
+	}
+	return text
+}
+
+// Formats an amount. receipt.Money is in minor units, other numbers are in
+// major units, so money 3.5 and money 350 of type receipt.Money are the same.
+func (s *Set) money(v any) (string, error) {
+	switch v := v.(type) {
+	case receipt.Money:
+		return s.currency.Format(v), nil
+	case float64:
+		return s.currency.Format(s.currency.Amount(v)), nil
+	case float32:
+		return s.currency.Format(s.currency.Amount(float64(v))), nil
+	case int:
+		return s.currency.Format(s.currency.Amount(float64(v))), nil
+	case int64:
+		return s.currency.Format(s.currency.Amount(float64(v))), nil
+	}
+	return "", fmt.Errorf("money needs a number, got %T", v)
+}
+
+// Named layouts of date.
+var dateLayouts = map[string]string{
+	"short":    "2006-01-02",
+	"long":     "Monday, 2 January 2006",
+	"time":     "15:04",
+	"datetime": "2006-01-02 15:04",
+}
+
+// Formats a time.Time, an RFC 3339 string or Unix seconds with a named
+// layout or a Go layout.
+func date(layout string, v any) (string, error) {
+	var t time.Time
+	switch v := v.(type) {
+	case time.Time:
+		t = v
+	case string:
+		var err error
+		if t, err = time.Parse(time.RFC3339, v); err != nil {
+			return "", err
+		}
+	case int:
+		t = time.Unix(int64(v), 0)
+	case int64:
+		t = time.Unix(v, 0)
+	default:
+		return "", fmt.Errorf("date needs a time, got %T", v)
+	}
+	if named, ok := dateLayouts[layout]; ok {
+		layout = named
+	}
+	return t.Format(layout), nil
+}